@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sojamann/ocapi/registry"
+	"github.com/spf13/cobra"
+)
+
+var flagOlderThan time.Duration
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the on disk cache",
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old cache entries",
+	Long:  "Remove tag lists and catalogs older than --cache-ttl and manifests not used for --older-than",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if flagCacheDir == "" {
+			return errors.New("no cache directory, set one with --cache-dir")
+		}
+
+		removed, err := registry.PruneCache(flagCacheDir, flagCacheTTL, flagOlderThan)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Removed %d cache entries\n", removed)
+		return nil
+	},
+}
+
+func init() {
+	cachePruneCmd.Flags().DurationVar(&flagOlderThan, "older-than", 30*24*time.Hour, "Remove manifests not used for this long (0 = all)")
+
+	cacheCmd.AddCommand(cachePruneCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sojamann/ocapi/image"
+	"github.com/spf13/cobra"
+)
+
+var flagFiles bool
+
+var imageDiffCmd = &cobra.Command{
+	Use:   "diff registry/image[:tag][@digest] registry/image[:tag][@digest]",
+	Short: "Compare two images",
+	Long:  "Compare the layers and the config of two images. With --files the layers are downloaded (the shared ones once) and the paths added, removed or modified from A to B are listed",
+	Args: cobra.MatchAll(
+		cobra.ExactArgs(2),
+		validateArgNo(0, image.ValidateImageSpecifier),
+		validateArgNo(1, image.ValidateImageSpecifier),
+	),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := resolveImage(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		b, err := resolveImage(cmd.Context(), args[1])
+		if err != nil {
+			return err
+		}
+
+		diff, err := image.Diff(cmd.Context(), a, b, flagFiles)
+		if err != nil {
+			return err
+		}
+
+		return printOutput([]*image.ImageDiff{diff}, func(d *image.ImageDiff) *image.ImageDiff { return d }, diffDetails, diffColumns...)
+	},
+}
+
+// Like resolveImages but the specifier must refer to exactly one image
+func resolveImage(ctx context.Context, specifier string) (*image.Image, error) {
+	imgs, err := resolveImages(ctx, specifier)
+	if err != nil {
+		return nil, err
+	}
+	if len(imgs) > 1 {
+		return nil, fmt.Errorf("%s has images for %d platforms, choose one with --platform", specifier, len(imgs))
+	}
+	return imgs[0], nil
+}
+
+func init() {
+	imageDiffCmd.Flags().BoolVar(&flagFiles, "files", false, "Download the layers and compare the files of the images")
+
+	imageCmd.AddCommand(imageDiffCmd)
+}
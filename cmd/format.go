@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sojamann/ocapi/image"
+	"github.com/sojamann/ocapi/registry"
+)
+
+// the parts of the config one usually looks for
+func configSummary(config *registry.ImageConfig) string {
+	builder := strings.Builder{}
+
+	if config.Created != nil {
+		writeValue(&builder, "", "Created", config.Created.Format(time.RFC3339))
+	}
+	writeValue(&builder, "", "Entrypoint", strings.Join(config.Config.Entrypoint, " "))
+	writeValue(&builder, "", "Cmd", strings.Join(config.Config.Cmd, " "))
+	writeList(&builder, "", "Env", config.Config.Env)
+	writeList(&builder, "", "Labels", keyValues(config.Config.Labels))
+
+	return builder.String()
+}
+
+// all of the container config, each line prefixed with indent
+func configDetails(config *registry.ImageConfig, indent string) string {
+	builder := strings.Builder{}
+
+	c := config.Config
+	writeValue(&builder, indent, "Entrypoint", strings.Join(c.Entrypoint, " "))
+	writeValue(&builder, indent, "Cmd", strings.Join(c.Cmd, " "))
+	writeValue(&builder, indent, "User", c.User)
+	writeValue(&builder, indent, "WorkingDir", c.WorkingDir)
+	writeValue(&builder, indent, "StopSignal", c.StopSignal)
+	writeList(&builder, indent, "Env", c.Env)
+	writeList(&builder, indent, "ExposedPorts", sortedKeys(c.ExposedPorts))
+	writeList(&builder, indent, "Volumes", sortedKeys(c.Volumes))
+	writeList(&builder, indent, "Labels", keyValues(c.Labels))
+
+	return builder.String()
+}
+
+// everything known about the image as shown by image inspect
+func imageDetails(img *image.Image, config *registry.ImageConfig) string {
+	builder := strings.Builder{}
+	manifest := img.Manifest()
+
+	writeValue(&builder, "", "Name", img.Reference())
+	writeValue(&builder, "", "MediaType", manifest.MediaType)
+	writeValue(&builder, "", "Digest", img.Digest())
+	if img.Platform() != nil {
+		writeValue(&builder, "", "Platform", img.Platform().String())
+	} else {
+		p := registry.Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+		writeValue(&builder, "", "Platform", p.String())
+	}
+	if config.Created != nil {
+		writeValue(&builder, "", "Created", config.Created.Format(time.RFC3339))
+	}
+	writeValue(&builder, "", "Author", config.Author)
+
+	// schema1 manifests don't know the size of their layers
+	if manifest.IsSchema1() {
+		writeValue(&builder, "", "Size", "unknown")
+		writeList(&builder, "", "Layers", manifest.LayerDigests())
+	} else {
+		var size int64
+		layers := make([]string, 0, len(manifest.Layers))
+		for _, layer := range manifest.Layers {
+			size += layer.Size
+			layers = append(layers, fmt.Sprintf("%s  %9s  %s", layer.Digest, humanSize(layer.Size), layer.MediaType))
+		}
+		writeValue(&builder, "", "Size", humanSize(size))
+		writeList(&builder, "", "Layers", layers)
+	}
+
+	if details := configDetails(config, "  "); details != "" {
+		builder.WriteString("Config:\n")
+		builder.WriteString(details)
+	}
+	writeList(&builder, "", "Annotations", keyValues(manifest.Annotations))
+
+	return builder.String()
+}
+
+// the differences of the images as shown by image diff
+func diffDetails(diff *image.ImageDiff) string {
+	builder := strings.Builder{}
+
+	writeValue(&builder, "", "A", diff.A)
+	writeValue(&builder, "", "B", diff.B)
+	writeValue(&builder, "", "Shared layers", strconv.Itoa(len(diff.SharedLayers)))
+	writeList(&builder, "", "Only in A", layerLines(diff.OnlyInA))
+	writeList(&builder, "", "Only in B", layerLines(diff.OnlyInB))
+	if diff.SizeDelta != 0 {
+		writeValue(&builder, "", "Size delta", signedSize(diff.SizeDelta))
+	}
+
+	config := make([]string, 0, len(diff.Config))
+	for _, change := range diff.Config {
+		name := change.Field
+		if change.Key != "" {
+			name += " " + change.Key
+		}
+		switch change.Kind {
+		case image.Added:
+			config = append(config, fmt.Sprintf("+ %s: %s", name, change.B))
+		case image.Removed:
+			config = append(config, fmt.Sprintf("- %s: %s", name, change.A))
+		default:
+			config = append(config, fmt.Sprintf("~ %s: %s -> %s", name, change.A, change.B))
+		}
+	}
+	writeList(&builder, "", "Config", config)
+
+	files := make([]string, 0, len(diff.Files))
+	for _, change := range diff.Files {
+		files = append(files, changeSymbol(change.Kind)+" "+change.Path)
+	}
+	writeList(&builder, "", "Files", files)
+
+	return builder.String()
+}
+
+func changeSymbol(kind image.ChangeKind) string {
+	switch kind {
+	case image.Added:
+		return "+"
+	case image.Removed:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+// digest and size of every layer
+func layerLines(layers []image.LayerInfo) []string {
+	lines := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		if layer.Size == 0 {
+			lines = append(lines, layer.Digest)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s  %9s", layer.Digest, humanSize(layer.Size)))
+	}
+	return lines
+}
+
+// writes "key: value" unless the value is empty
+func writeValue(builder *strings.Builder, indent, key, value string) {
+	if value == "" {
+		return
+	}
+	fmt.Fprintf(builder, "%s%s: %s\n", indent, key, value)
+}
+
+// writes the key followed by the items on their own lines unless there are none
+func writeList(builder *strings.Builder, indent, key string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(builder, "%s%s:\n", indent, key)
+	for _, item := range items {
+		fmt.Fprintf(builder, "%s  %s\n", indent, item)
+	}
+}
+
+// returns key=value sorted by key
+func keyValues(m map[string]string) []string {
+	items := make([]string, 0, len(m))
+	for _, key := range sortedKeys(m) {
+		items = append(items, key+"="+m[key])
+	}
+	return items
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// like humanSize but always with a sign
+func signedSize(size int64) string {
+	if size < 0 {
+		return "-" + humanSize(-size)
+	}
+	return "+" + humanSize(size)
+}
+
+// formats the size with a decimal unit e.g. 12.3 MB
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+
+	value := float64(size)
+	unit := 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sojamann/ocapi/image"
+	"github.com/spf13/cobra"
+)
+
+var flagGraphFormat string
+
+const (
+	graphTree    = "tree"
+	graphDot     = "dot"
+	graphMermaid = "mermaid"
+	graphJSON    = "json"
+)
+
+var graphFormats = []string{graphTree, graphDot, graphMermaid, graphJSON}
+
+var graphCmd = &cobra.Command{
+	Use:   "graph registry/images/*:*",
+	Short: "Show which images are based on which",
+	Long:  "Show the images matching the pattern as a forest in which every image is a child of its closest parent, the image with the most layers whose layers are a strict prefix of its own. The graph is printed as an indented tree, a Graphviz DOT or Mermaid graph or as JSON (see --format); --output can be used instead of --format for the other machine readable formats",
+	Args: cobra.MatchAll(
+		cobra.ExactArgs(1),
+		validateArgNo(0, image.ValidateImagePattern),
+	),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("format") && flagOutput != outputText {
+			return fmt.Errorf("--format can't be combined with --output %s", flagOutput)
+		}
+
+		// dot and mermaid render the whole graph, the
+		// other formats are printed root by root
+		var render func([]*image.GraphNode) string
+		switch flagGraphFormat {
+		case graphTree:
+		case graphJSON:
+			flagOutput = outputJSON
+		case graphDot:
+			render = renderDot
+		case graphMermaid:
+			render = renderMermaid
+		default:
+			return fmt.Errorf("unknown graph format '%s' (one of %s)", flagGraphFormat, strings.Join(graphFormats, ", "))
+		}
+
+		imgPattern := image.ImagePattern(args[0])
+		imgs, expandErr := imgPattern.ExpandToImages(cmd.Context(), platform, errorPolicy())
+		if expandErr != nil && !isPartialFailure(expandErr) {
+			return expandErr
+		}
+
+		roots := image.Graph(imgs)
+		if render != nil {
+			fmt.Print(render(roots))
+		} else if err := printOutput(roots, func(node *image.GraphNode) *image.GraphNode { return node }, func(node *image.GraphNode) string {
+			return renderTree([]*image.GraphNode{node})
+		}, graphColumns...); err != nil {
+			return err
+		}
+
+		exitOnPartialFailure(expandErr)
+		return nil
+	},
+}
+
+// the name of the image with its platform as multiple may share a tag
+func nodeLabel(node *image.GraphNode) string {
+	if node.Platform == "" {
+		return node.Name
+	}
+	return fmt.Sprintf("%s (%s)", node.Name, node.Platform)
+}
+
+// every root followed by its descendants drawn as a tree
+func renderTree(roots []*image.GraphNode) string {
+	builder := strings.Builder{}
+
+	var writeChildren func(node *image.GraphNode, indent string)
+	writeChildren = func(node *image.GraphNode, indent string) {
+		for i, child := range node.Children {
+			branch, next := "├── ", "│   "
+			if i == len(node.Children)-1 {
+				branch, next = "└── ", "    "
+			}
+			builder.WriteString(indent + branch + nodeLabel(child) + "\n")
+			writeChildren(child, indent+next)
+		}
+	}
+
+	for _, root := range roots {
+		builder.WriteString(nodeLabel(root) + "\n")
+		writeChildren(root, "")
+	}
+
+	return builder.String()
+}
+
+// a Graphviz digraph with an edge from every parent to its children
+func renderDot(roots []*image.GraphNode) string {
+	builder := strings.Builder{}
+	builder.WriteString("digraph images {\n")
+	builder.WriteString("  rankdir=LR;\n")
+
+	walkGraph(roots, func(parent, node *image.GraphNode) {
+		if parent == nil {
+			fmt.Fprintf(&builder, "  %s;\n", strconv.Quote(nodeLabel(node)))
+			return
+		}
+		fmt.Fprintf(&builder, "  %s -> %s;\n", strconv.Quote(nodeLabel(parent)), strconv.Quote(nodeLabel(node)))
+	})
+
+	builder.WriteString("}\n")
+	return builder.String()
+}
+
+// a Mermaid flowchart with an edge from every parent to its children
+func renderMermaid(roots []*image.GraphNode) string {
+	builder := strings.Builder{}
+	builder.WriteString("graph LR\n")
+
+	// mermaid ids can't contain the characters of image names
+	ids := make(map[*image.GraphNode]string)
+	walkGraph(roots, func(parent, node *image.GraphNode) {
+		ids[node] = "n" + strconv.Itoa(len(ids))
+		fmt.Fprintf(&builder, "  %s[\"%s\"]\n", ids[node], nodeLabel(node))
+		if parent != nil {
+			fmt.Fprintf(&builder, "  %s --> %s\n", ids[parent], ids[node])
+		}
+	})
+
+	return builder.String()
+}
+
+// calls fn for every node depth first, parents before their
+// children; the parent of the roots is nil
+func walkGraph(roots []*image.GraphNode, fn func(parent, node *image.GraphNode)) {
+	var walk func(parent, node *image.GraphNode)
+	walk = func(parent, node *image.GraphNode) {
+		fn(parent, node)
+		for _, child := range node.Children {
+			walk(node, child)
+		}
+	}
+
+	for _, root := range roots {
+		walk(nil, root)
+	}
+}
+
+func init() {
+	graphCmd.Flags().StringVar(&flagGraphFormat, "format", graphTree, "Format of the graph: tree, dot, mermaid or json (json is the same as --output json)")
+
+	rootCmd.AddCommand(graphCmd)
+}
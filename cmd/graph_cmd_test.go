@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/sojamann/ocapi/image"
+)
+
+// base
+// ├── app (linux/amd64)
+// └── tool
+// other
+func testGraph() []*image.GraphNode {
+	node := func(name, platform string, children ...*image.GraphNode) *image.GraphNode {
+		return &image.GraphNode{Name: "registry.example.com/" + name + ":1", Platform: platform, Children: children}
+	}
+	return []*image.GraphNode{
+		node("base", "", node("app", "linux/amd64"), node("tool", "")),
+		node("other", ""),
+	}
+}
+
+func TestRenderTree(t *testing.T) {
+	want := `registry.example.com/base:1
+├── registry.example.com/app:1 (linux/amd64)
+└── registry.example.com/tool:1
+registry.example.com/other:1
+`
+	if got := renderTree(testGraph()); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestRenderDot(t *testing.T) {
+	want := `digraph images {
+  rankdir=LR;
+  "registry.example.com/base:1";
+  "registry.example.com/base:1" -> "registry.example.com/app:1 (linux/amd64)";
+  "registry.example.com/base:1" -> "registry.example.com/tool:1";
+  "registry.example.com/other:1";
+}
+`
+	if got := renderDot(testGraph()); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestRenderMermaid(t *testing.T) {
+	want := `graph LR
+  n0["registry.example.com/base:1"]
+  n1["registry.example.com/app:1 (linux/amd64)"]
+  n0 --> n1
+  n2["registry.example.com/tool:1"]
+  n0 --> n2
+  n3["registry.example.com/other:1"]
+`
+	if got := renderMermaid(testGraph()); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
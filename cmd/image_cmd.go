@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -32,20 +33,23 @@ var imageLsCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		specifiers, err := imagePattern.ExpandToSpecifiers()
-		if err != nil {
+		specifiers, err := imagePattern.ExpandToSpecifiers(cmd.Context(), errorPolicy())
+		if err != nil && !isPartialFailure(err) {
 			fmt.Fprintf(os.Stderr, "Err: %v\n", err)
 			os.Exit(1)
 		}
 
-		for _, s := range specifiers {
-			fmt.Printf("%s/%s:%s\n", s.Registry.Host, s.ImageName, s.Tag)
+		if err := printOutput(specifiers, image.ImageSpecifier.Info, image.ImageSpecifier.String, specifierColumns...); err != nil {
+			fmt.Fprintf(os.Stderr, "Err: %v\n", err)
+			os.Exit(1)
 		}
+
+		exitOnPartialFailure(err)
 	},
 }
 
 var imageShowCmd = &cobra.Command{
-	Use:   "show registry/image:tag",
+	Use:   "show registry/image[:tag][@digest]",
 	Short: "Show OCI image",
 	Long:  "Show OCI image",
 	Args: cobra.MatchAll(
@@ -53,24 +57,26 @@ var imageShowCmd = &cobra.Command{
 		validateArgNo(0, image.ValidateImageSpecifier),
 	),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		imageSpecifier, err := image.ImageSpecifierParse(args[0])
+		imgs, err := resolveImages(cmd.Context(), args[0])
 		if err != nil {
 			return err
 		}
 
-		img, err := imageSpecifier.ToImage()
-		if err != nil {
-			return err
+		for _, img := range imgs {
+			if _, err := img.Config(cmd.Context()); err != nil {
+				return err
+			}
 		}
 
-		fmt.Println(img)
-
-		return nil
+		return printOutput(imgs, (*image.Image).Info, func(img *image.Image) string {
+			config, _ := img.Config(cmd.Context())
+			return img.String() + "\n" + configSummary(config)
+		}, imageColumns...)
 	},
 }
 
 var imageBasedOnCmd = &cobra.Command{
-	Use:   "based-on registry/image:tag registry/images/*:*",
+	Use:   "based-on registry/image[:tag][@digest] registry/images/*:*",
 	Short: "Check parent images",
 	Long:  "List all images matching the pattern on which the specified image a is based on",
 	Args: cobra.MatchAll(
@@ -79,30 +85,36 @@ var imageBasedOnCmd = &cobra.Command{
 		validateArgNo(1, image.ValidateImagePattern),
 	),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		childImgSpecifier, err := image.ImageSpecifierParse(args[0])
+		childImgSpecifier, err := image.ImageSpecifierParse(cmd.Context(), args[0])
 		if err != nil {
 			return err
 		}
-		childImg, err := childImgSpecifier.ToImage()
+		childImgs, err := childImgSpecifier.ToImages(cmd.Context(), platform)
 		if err != nil {
 			return err
 		}
 
 		parentImgPattern := image.ImagePattern(args[1])
-		parentImgs, err := parentImgPattern.ExpandToImages()
-		if err != nil {
-			return err
+		parentImgs, expandErr := parentImgPattern.ExpandToImages(cmd.Context(), platform, errorPolicy())
+		if expandErr != nil && !isPartialFailure(expandErr) {
+			return expandErr
 		}
 
-		matched := false
-		for _, parentImg := range parentImgs {
-			if parentImg.IsParentOf(childImg) {
-				fmt.Println(parentImg.FullyQualifiedName())
-				matched = true
+		matches := make([]*image.Image, 0)
+		for _, childImg := range childImgs {
+			for _, parentImg := range parentImgs {
+				if parentImg.IsParentOf(childImg) {
+					matches = append(matches, parentImg)
+				}
 			}
 		}
 
-		if matched {
+		if err := printOutput(matches, (*image.Image).Info, imageRef, imageColumns...); err != nil {
+			return err
+		}
+		exitOnPartialFailure(expandErr)
+
+		if len(matches) != 0 {
 			os.Exit(0)
 		} else {
 			os.Exit(1)
@@ -113,7 +125,7 @@ var imageBasedOnCmd = &cobra.Command{
 }
 
 var imageBaseOfCmd = &cobra.Command{
-	Use:   "base-of registry/image:tag registry/images/*:*",
+	Use:   "base-of registry/image[:tag][@digest] registry/images/*:*",
 	Short: "Check child images",
 	Long:  "List all images matching the pattern on which the specified image a is the base of",
 	Args: cobra.MatchAll(
@@ -122,30 +134,36 @@ var imageBaseOfCmd = &cobra.Command{
 		validateArgNo(1, image.ValidateImagePattern),
 	),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		parentImgSpecifier, err := image.ImageSpecifierParse(args[0])
+		parentImgSpecifier, err := image.ImageSpecifierParse(cmd.Context(), args[0])
 		if err != nil {
 			return err
 		}
-		parentImg, err := parentImgSpecifier.ToImage()
+		parentImgs, err := parentImgSpecifier.ToImages(cmd.Context(), platform)
 		if err != nil {
 			return err
 		}
 
 		childImgPattern := image.ImagePattern(args[1])
-		childImgs, err := childImgPattern.ExpandToImages()
-		if err != nil {
-			return err
+		childImgs, expandErr := childImgPattern.ExpandToImages(cmd.Context(), platform, errorPolicy())
+		if expandErr != nil && !isPartialFailure(expandErr) {
+			return expandErr
 		}
 
-		matched := false
-		for _, childImg := range childImgs {
-			if parentImg.IsParentOf(childImg) {
-				fmt.Println(childImg.FullyQualifiedName())
-				matched = true
+		matches := make([]*image.Image, 0)
+		for _, parentImg := range parentImgs {
+			for _, childImg := range childImgs {
+				if parentImg.IsParentOf(childImg) {
+					matches = append(matches, childImg)
+				}
 			}
 		}
 
-		if matched {
+		if err := printOutput(matches, (*image.Image).Info, imageRef, imageColumns...); err != nil {
+			return err
+		}
+		exitOnPartialFailure(expandErr)
+
+		if len(matches) != 0 {
 			os.Exit(0)
 		} else {
 			os.Exit(1)
@@ -155,6 +173,37 @@ var imageBaseOfCmd = &cobra.Command{
 	},
 }
 
+// Returns the images of the specifier for --platform. It is
+// an error if there are none.
+func resolveImages(ctx context.Context, specifier string) ([]*image.Image, error) {
+	imageSpecifier, err := image.ImageSpecifierParse(ctx, specifier)
+	if err != nil {
+		return nil, err
+	}
+
+	imgs, err := imageSpecifier.ToImages(ctx, platform)
+	if err != nil {
+		return nil, err
+	}
+	if len(imgs) == 0 && platform != nil {
+		return nil, fmt.Errorf("%s has no image for platform %s", imageSpecifier, platform)
+	}
+	if len(imgs) == 0 {
+		return nil, fmt.Errorf("%s has no image for any known platform", imageSpecifier)
+	}
+
+	return imgs, nil
+}
+
+// the fully qualified name of the image including the digest
+// and the platform if known as multiple may share a tag
+func imageRef(img *image.Image) string {
+	if img.Platform() == nil {
+		return img.Reference()
+	}
+	return fmt.Sprintf("%s (%s)", img.Reference(), img.Platform())
+}
+
 func init() {
 	imageCmd.AddCommand(imageLsCmd)
 	imageCmd.AddCommand(imageShowCmd)
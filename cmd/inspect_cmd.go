@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sojamann/ocapi/image"
+	"github.com/sojamann/ocapi/registry"
+	"github.com/spf13/cobra"
+)
+
+var flagRaw bool
+
+var imageInspectCmd = &cobra.Command{
+	Use:   "inspect registry/image[:tag][@digest]",
+	Short: "Show the metadata of an image",
+	Long:  "Show the manifest, layers, platform, config and annotations of the image. With --raw the manifest the reference points to (an index for multi-platform images) is printed as returned by the registry, with --platform the manifest of that platform",
+	Args: cobra.MatchAll(
+		cobra.ExactArgs(1),
+		validateArgNo(0, image.ValidateImageSpecifier),
+	),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// the manifest the reference points to, which may be an index;
+		// only a platform picks the manifest of an image from it
+		if flagRaw && platform == nil {
+			specifier, err := image.ImageSpecifierParse(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			manifest, err := specifier.Manifest(cmd.Context())
+			if err != nil {
+				return err
+			}
+			os.Stdout.Write(manifest.Raw)
+			fmt.Println()
+			return nil
+		}
+
+		imgs, err := resolveImages(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+
+		for _, img := range imgs {
+			if flagRaw {
+				os.Stdout.Write(img.Manifest().Raw)
+				fmt.Println()
+				continue
+			}
+
+			if _, err := img.Config(cmd.Context()); err != nil {
+				return err
+			}
+		}
+		if flagRaw {
+			return nil
+		}
+
+		return printOutput(imgs, (*image.Image).Info, func(img *image.Image) string {
+			config, _ := img.Config(cmd.Context())
+			return separated(img == imgs[0], imageDetails(img, config))
+		}, imageColumns...)
+	},
+}
+
+var imageConfigCmd = &cobra.Command{
+	Use:   "config registry/image[:tag][@digest]",
+	Short: "Show the config of an image",
+	Long:  "Show the entrypoint, cmd, env, user, workdir, exposed ports, volumes and labels of the image. With --raw the config is printed as returned by the registry",
+	Args: cobra.MatchAll(
+		cobra.ExactArgs(1),
+		validateArgNo(0, image.ValidateImageSpecifier),
+	),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		imgs, err := resolveImages(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+
+		for _, img := range imgs {
+			config, err := img.Config(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			if flagRaw {
+				os.Stdout.Write(config.Raw)
+				fmt.Println()
+			}
+		}
+		if flagRaw {
+			return nil
+		}
+
+		return printOutput(imgs, func(img *image.Image) *registry.ImageConfig {
+			config, _ := img.Config(cmd.Context())
+			return config
+		}, func(img *image.Image) string {
+			config, _ := img.Config(cmd.Context())
+			// multiple images share the tag of an index
+			if len(imgs) > 1 {
+				return separated(img == imgs[0], imageRef(img)+"\n"+configDetails(config, ""))
+			}
+			return configDetails(config, "")
+		}, configColumns...)
+	},
+}
+
+// separates the text of an item from the one before by an empty line
+func separated(first bool, text string) string {
+	if first {
+		return text
+	}
+	return "\n" + text
+}
+
+func init() {
+	imageInspectCmd.Flags().BoolVar(&flagRaw, "raw", false, "Print the manifest as returned by the registry")
+	imageConfigCmd.Flags().BoolVar(&flagRaw, "raw", false, "Print the config as returned by the registry")
+
+	imageCmd.AddCommand(imageInspectCmd)
+	imageCmd.AddCommand(imageConfigCmd)
+}
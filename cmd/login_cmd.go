@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sojamann/ocapi/registry"
+	"github.com/spf13/cobra"
+)
+
+var flagUsername string
+var flagPasswordStdin bool
+
+var loginCmd = &cobra.Command{
+	Use:   "login registry",
+	Short: "Store credentials for a registry",
+	Long:  "Verify the credentials against the registry and store them in ocapi's auth file (--auth-file)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		host := args[0]
+
+		if flagUsername == "" {
+			return errors.New("--username is required")
+		}
+		if !flagPasswordStdin {
+			return errors.New("--password-stdin is required")
+		}
+
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		password := strings.TrimRight(string(content), "\r\n")
+
+		registry.SetCredentials(host, flagUsername, password)
+		r, err := registry.NewRegisty(cmd.Context(), host)
+		if err != nil {
+			return err
+		}
+		if err := r.VerifyCredentials(cmd.Context()); err != nil {
+			return fmt.Errorf("login to %s failed: %w", host, err)
+		}
+
+		if err := registry.SaveCredentials(flagAuthFile, host); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(os.Stderr, "Login succeeded")
+		return nil
+	},
+}
+
+func init() {
+	loginCmd.Flags().StringVarP(&flagUsername, "username", "u", "", "Username")
+	loginCmd.Flags().BoolVar(&flagPasswordStdin, "password-stdin", false, "Read the password from stdin")
+
+	rootCmd.AddCommand(loginCmd)
+}
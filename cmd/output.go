@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/sojamann/ocapi/image"
+	"github.com/sojamann/ocapi/registry"
+	"gopkg.in/yaml.v3"
+)
+
+var flagOutput string
+var flagTemplate string
+
+const (
+	outputText     = "text"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputTable    = "table"
+	outputTemplate = "template"
+)
+
+var outputFormats = []string{outputText, outputJSON, outputYAML, outputTable, outputTemplate}
+
+// a column of --output table
+type column[T any] struct {
+	name  string
+	value func(T) string
+}
+
+func validateOutput() error {
+	for _, format := range outputFormats {
+		if flagOutput == format {
+			if format == outputTemplate && flagTemplate == "" {
+				return fmt.Errorf("--output %s requires --template", outputTemplate)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format '%s' (one of %s)", flagOutput, strings.Join(outputFormats, ", "))
+}
+
+// Prints the items in the format chosen by --output. text prints an
+// item for humans, all other formats print the serializable info of the
+// items. JSON and YAML always print a list, the template is executed
+// for every item.
+func printOutput[T, I any](items []T, info func(T) I, text func(T) string, columns ...column[I]) error {
+	if flagOutput == outputText {
+		for _, item := range items {
+			s := text(item)
+			if !strings.HasSuffix(s, "\n") {
+				s += "\n"
+			}
+			fmt.Print(s)
+		}
+		return nil
+	}
+
+	infos := make([]I, 0, len(items))
+	for _, item := range items {
+		infos = append(infos, info(item))
+	}
+
+	switch flagOutput {
+	case outputJSON:
+		content, err := json.MarshalIndent(infos, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+
+	case outputYAML:
+		content, err := toYAML(infos)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(content))
+
+	case outputTable:
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		names := make([]string, 0, len(columns))
+		for _, c := range columns {
+			names = append(names, strings.ToUpper(c.name))
+		}
+		fmt.Fprintln(writer, strings.Join(names, "\t"))
+		for _, info := range infos {
+			values := make([]string, 0, len(columns))
+			for _, c := range columns {
+				values = append(values, c.value(info))
+			}
+			fmt.Fprintln(writer, strings.Join(values, "\t"))
+		}
+		return writer.Flush()
+
+	case outputTemplate:
+		tmpl, err := template.New("output").Parse(flagTemplate)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if err := tmpl.Execute(os.Stdout, info); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+	}
+
+	return nil
+}
+
+// Converts v to YAML using the json field names and order. YAML being
+// a superset of JSON the JSON is parsed as YAML and printed in block style.
+func toYAML(v any) ([]byte, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(content, &node); err != nil {
+		return nil, err
+	}
+	resetStyle(&node)
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), encoder.Close()
+}
+
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
+
+var specifierColumns = []column[image.ImageSpecifierInfo]{
+	{"registry", func(i image.ImageSpecifierInfo) string { return i.Registry }},
+	{"name", func(i image.ImageSpecifierInfo) string { return i.Name }},
+	{"tag", func(i image.ImageSpecifierInfo) string { return i.Tag }},
+	{"digest", func(i image.ImageSpecifierInfo) string { return i.Digest }},
+}
+
+var imageColumns = []column[image.ImageInfo]{
+	{"name", func(i image.ImageInfo) string { return formatName(i.Registry, i.Name, i.Tag) }},
+	{"platform", func(i image.ImageInfo) string { return i.Platform }},
+	{"digest", func(i image.ImageInfo) string { return i.Digest }},
+	{"layers", func(i image.ImageInfo) string { return strconv.Itoa(len(i.Layers)) }},
+	{"size", func(i image.ImageInfo) string {
+		if i.Size == 0 {
+			return ""
+		}
+		return humanSize(i.Size)
+	}},
+}
+
+var configColumns = []column[*registry.ImageConfig]{
+	{"created", func(c *registry.ImageConfig) string {
+		if c.Created == nil {
+			return ""
+		}
+		return c.Created.Format(time.RFC3339)
+	}},
+	{"entrypoint", func(c *registry.ImageConfig) string { return strings.Join(c.Config.Entrypoint, " ") }},
+	{"cmd", func(c *registry.ImageConfig) string { return strings.Join(c.Config.Cmd, " ") }},
+	{"user", func(c *registry.ImageConfig) string { return c.Config.User }},
+	{"workdir", func(c *registry.ImageConfig) string { return c.Config.WorkingDir }},
+}
+
+var diffColumns = []column[*image.ImageDiff]{
+	{"a", func(d *image.ImageDiff) string { return d.A }},
+	{"b", func(d *image.ImageDiff) string { return d.B }},
+	{"shared", func(d *image.ImageDiff) string { return strconv.Itoa(len(d.SharedLayers)) }},
+	{"only a", func(d *image.ImageDiff) string { return strconv.Itoa(len(d.OnlyInA)) }},
+	{"only b", func(d *image.ImageDiff) string { return strconv.Itoa(len(d.OnlyInB)) }},
+	{"size delta", func(d *image.ImageDiff) string {
+		if d.SizeDelta == 0 {
+			return ""
+		}
+		return signedSize(d.SizeDelta)
+	}},
+	{"config", func(d *image.ImageDiff) string { return strconv.Itoa(len(d.Config)) }},
+	{"files", func(d *image.ImageDiff) string { return strconv.Itoa(len(d.Files)) }},
+}
+
+// the columns describe the roots of the graph
+var graphColumns = []column[*image.GraphNode]{
+	{"reference", func(n *image.GraphNode) string { return n.Reference }},
+	{"platform", func(n *image.GraphNode) string { return n.Platform }},
+	{"layers", func(n *image.GraphNode) string { return strconv.Itoa(n.Layers) }},
+	{"descendants", func(n *image.GraphNode) string {
+		descendants := -1
+		walkGraph([]*image.GraphNode{n}, func(parent, node *image.GraphNode) { descendants++ })
+		return strconv.Itoa(descendants)
+	}},
+}
+
+// registry/name[:tag]
+func formatName(registry, name, tag string) string {
+	if tag == "" {
+		return registry + "/" + name
+	}
+	return registry + "/" + name + ":" + tag
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// true if there is someone to ask for credentials
+func isInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd()))
+}
+
+// Asks for the credentials of the host on the terminal without
+// echoing the password (see registry.CredentialPrompt)
+func promptCredentials(host string, previous error) (string, string, bool, error) {
+	if previous != nil {
+		fmt.Fprintf(os.Stderr, "Login to %s failed: %v\n", host, previous)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s requires credentials (leave empty to continue anonymously)\n", host)
+	}
+
+	fmt.Fprint(os.Stderr, "Username: ")
+	username, err := stdin.ReadString('\n')
+	if err != nil {
+		return "", "", false, err
+	}
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return "", "", false, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	fmt.Fprintf(os.Stderr, "Save credentials to %s? [y/N] ", flagAuthFile)
+	answer, err := stdin.ReadString('\n')
+	if err != nil {
+		return "", "", false, err
+	}
+	save := strings.EqualFold(strings.TrimSpace(answer), "y")
+
+	return username, string(password), save, nil
+}
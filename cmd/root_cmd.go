@@ -1,34 +1,166 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/sojamann/ocapi/image"
 	"github.com/sojamann/ocapi/registry"
 	"github.com/spf13/cobra"
 )
 
 var flagDockerConfig string
+var flagAuthFile string
+var flagConfig string
+var flagInsecureRegistries []string
+var flagSkipTLSVerify []string
+var flagCertsDirs []string
 var flagDebug bool
+var flagPlatform string
+var flagPageSize int
+var flagRetries int
+var flagConcurrency int
+var flagHostConcurrency map[string]int
+var flagRateLimit float64
+var flagTimeout time.Duration
+var flagFailFast bool
+var flagKeepGoing bool
+var flagNoCache bool
+var flagCacheDir string
+var flagCacheTTL time.Duration
+var flagNoTokenCache bool
+
+// cancels the context with the timeout if there is one
+var cancelTimeout context.CancelFunc = func() {}
+
+// the parsed flagPlatform; nil means all platforms
+var platform *registry.Platform
 
 var rootCmd = &cobra.Command{
 	Use:   "ocapi",
 	Short: "ocapi short desc- ....",
 	Long:  "ocapi long desc- ....",
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOutput(); err != nil {
+			return err
+		}
+
 		if flagDebug {
 			zerolog.SetGlobalLevel(zerolog.DebugLevel)
 		} else {
 			zerolog.SetGlobalLevel(zerolog.Disabled)
 		}
-		return registry.LoadCredentialsFromDockerConfig(flagDockerConfig)
+
+		if flagTimeout > 0 {
+			ctx, cancel := context.WithTimeout(cmd.Context(), flagTimeout)
+			cmd.SetContext(ctx)
+			cancelTimeout = cancel
+		}
+
+		registry.PageSize = flagPageSize
+		registry.MaxRetries = flagRetries
+		registry.DefaultConcurrency = flagConcurrency
+		registry.DefaultRequestsPerSecond = flagRateLimit
+
+		if err := registry.LoadConfig(flagConfig); err != nil {
+			return err
+		}
+		for _, host := range flagInsecureRegistries {
+			registry.SetInsecure(host)
+		}
+		for _, host := range flagSkipTLSVerify {
+			registry.SetSkipVerify(host)
+		}
+		registry.CertsDirs = flagCertsDirs
+		for host, n := range flagHostConcurrency {
+			registry.SetConcurrency(host, n)
+		}
+
+		// without a cache directory ocapi still works, only slower
+		if flagCacheDir == "" {
+			dir, err := registry.DefaultCacheDir()
+			if err != nil {
+				log.Debug().Err(err).Msg("disabling the cache")
+				flagNoCache, flagNoTokenCache = true, true
+			}
+			flagCacheDir = dir
+		}
+		if !flagNoCache {
+			registry.EnableCache(flagCacheDir, flagCacheTTL)
+		}
+		if !flagNoTokenCache {
+			registry.EnableTokenCache(filepath.Join(flagCacheDir, "tokens.json"))
+		}
+
+		if flagPlatform != "" {
+			p, err := registry.ParsePlatform(flagPlatform)
+			if err != nil {
+				return err
+			}
+			platform = p
+		}
+
+		// without a terminal registries are accessed anonymously
+		if isInteractive() {
+			registry.CredentialPrompt = promptCredentials
+		}
+
+		return registry.LoadCredentials(flagDockerConfig, flagAuthFile)
 	},
 }
 
+// the policy for pattern expansions chosen via --fail-fast / --keep-going;
+// --fail-fast=false is the same as --keep-going
+func errorPolicy() image.ErrorPolicy {
+	if flagKeepGoing || !flagFailFast {
+		return image.KeepGoing
+	}
+	return image.FailFast
+}
+
+// true if the error only reports parts of a pattern that failed (--keep-going)
+func isPartialFailure(err error) bool {
+	var expErr *image.ExpansionError
+	return errors.As(err, &expErr)
+}
+
+// Exits with 1 after the results of a --keep-going expansion were
+// printed if parts of the pattern failed, like on any other error
+func exitOnPartialFailure(err error) {
+	if err == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Err: %v\n", err)
+	os.Exit(1)
+}
+
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// the first Ctrl-C cancels all requests, the second one kills
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
+	cancelTimeout()
+	if err != nil {
 		fmt.Println(err)
+
+		// the first error is already part of the message
+		var regErr *registry.RegistryError
+		if errors.As(err, &regErr) && len(regErr.Errors) > 1 {
+			for _, detail := range regErr.Errors[1:] {
+				fmt.Printf("  %s: %s\n", detail.Code, detail.Message)
+			}
+		}
 		os.Exit(1)
 	}
 }
@@ -40,10 +172,132 @@ func init() {
 		"~/.docker/config.json",
 		"Path to the config with the credentials",
 	)
+	rootCmd.PersistentFlags().IntVar(
+		&flagRetries,
+		"retries",
+		registry.MaxRetries,
+		"How often GET/HEAD requests are retried on network errors, 429 and 5xx",
+	)
+	rootCmd.PersistentFlags().IntVar(
+		&flagConcurrency,
+		"concurrency",
+		registry.DefaultConcurrency,
+		"Number of parallel requests per registry host",
+	)
+	rootCmd.PersistentFlags().StringToIntVar(
+		&flagHostConcurrency,
+		"host-concurrency",
+		nil,
+		"Number of parallel requests for specific hosts e.g. my.registry=20",
+	)
+	rootCmd.PersistentFlags().Float64Var(
+		&flagRateLimit,
+		"rate-limit",
+		registry.DefaultRequestsPerSecond,
+		"Maximum requests per second per registry host (0 = unlimited)",
+	)
+	rootCmd.PersistentFlags().DurationVar(
+		&flagTimeout,
+		"timeout",
+		0,
+		"Abort after this duration e.g. 30s or 5m (0 = no timeout)",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&flagFailFast,
+		"fail-fast",
+		true,
+		"Abort a pattern expansion on the first failing repository or image (--fail-fast=false is the same as --keep-going)",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&flagKeepGoing,
+		"keep-going",
+		false,
+		"Continue a pattern expansion when repositories or images fail and report all failures at the end",
+	)
+	rootCmd.MarkFlagsMutuallyExclusive("fail-fast", "keep-going")
+	rootCmd.PersistentFlags().StringVar(
+		&flagAuthFile,
+		"auth-file",
+		"~/.config/ocapi/auth.json",
+		"Path to ocapi's own credentials (see login)",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&flagConfig,
+		"config",
+		"~/.config/ocapi/config.json",
+		"Path to the ocapi config with per registry settings",
+	)
+	rootCmd.PersistentFlags().StringSliceVar(
+		&flagInsecureRegistries,
+		"insecure-registry",
+		nil,
+		"Registry hosts to talk to via plain HTTP",
+	)
+	rootCmd.PersistentFlags().StringSliceVar(
+		&flagSkipTLSVerify,
+		"tls-skip-verify",
+		nil,
+		"Registry hosts whose certificate is not verified",
+	)
+	rootCmd.PersistentFlags().StringSliceVar(
+		&flagCertsDirs,
+		"certs-dir",
+		registry.CertsDirs,
+		"Directories with CAs and client certificates per host (docker certs.d layout)",
+	)
 	rootCmd.PersistentFlags().BoolVar(
 		&flagDebug,
 		"debug",
 		false,
 		"Log everything...",
 	)
+	rootCmd.PersistentFlags().StringVar(
+		&flagPlatform,
+		"platform",
+		"",
+		"Only consider images for this platform (os/arch[/variant]) when a tag is multi-platform",
+	)
+	rootCmd.PersistentFlags().IntVar(
+		&flagPageSize,
+		"page-size",
+		registry.PageSize,
+		"Number of entries to request per page when listing repositories or tags (0 = registry default)",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&flagNoCache,
+		"no-cache",
+		false,
+		"Don't read or write the on disk cache",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&flagCacheDir,
+		"cache-dir",
+		"",
+		"Directory of the cache for manifests, tag lists and catalogs (default $XDG_CACHE_HOME/ocapi or ~/.cache/ocapi)",
+	)
+	rootCmd.PersistentFlags().DurationVar(
+		&flagCacheTTL,
+		"cache-ttl",
+		5*time.Minute,
+		"How long tag lists and catalogs are cached (0 = don't cache them); manifests are cached by digest",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&flagNoTokenCache,
+		"no-token-cache",
+		false,
+		"Don't persist bearer tokens in the cache directory for reuse by later runs",
+	)
+	rootCmd.PersistentFlags().StringVarP(
+		&flagOutput,
+		"output",
+		"o",
+		outputText,
+		"Output format: text, json, yaml, table or template",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&flagTemplate,
+		"template",
+		"",
+		"Go template executed for every item with --output template",
+	)
 }
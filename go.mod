@@ -7,6 +7,8 @@ require (
 	github.com/rs/zerolog v1.29.0
 	github.com/schollz/progressbar/v3 v3.13.1
 	github.com/spf13/cobra v1.6.1
+	golang.org/x/term v0.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -18,5 +20,4 @@ require (
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/sys v0.6.0 // indirect
-	golang.org/x/term v0.6.0 // indirect
 )
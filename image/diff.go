@@ -0,0 +1,156 @@
+package image
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/sojamann/ocapi/registry"
+)
+
+// ImageDiff describes what changes from image A to image B
+type ImageDiff struct {
+	A string `json:"a"`
+	B string `json:"b"`
+	// the layers both images start with
+	SharedLayers []LayerInfo `json:"sharedLayers"`
+	OnlyInA      []LayerInfo `json:"onlyInA"`
+	OnlyInB      []LayerInfo `json:"onlyInB"`
+	// size of B minus the size of A; 0 if a size is unknown (schema1)
+	SizeDelta int64          `json:"sizeDelta"`
+	Config    []ConfigChange `json:"config"`
+	// only set if the files were compared
+	Files []FileChange `json:"files,omitempty"`
+}
+
+type ChangeKind string
+
+const (
+	Added    ChangeKind = "added"
+	Removed  ChangeKind = "removed"
+	Modified ChangeKind = "modified"
+)
+
+// ConfigChange is a difference of the container configs
+type ConfigChange struct {
+	// env, label, entrypoint, cmd, user or workdir
+	Field string `json:"field"`
+	// the variable or label name; empty for the other fields
+	Key  string     `json:"key,omitempty"`
+	Kind ChangeKind `json:"kind"`
+	A    string     `json:"a,omitempty"`
+	B    string     `json:"b,omitempty"`
+}
+
+// FileChange is a path that differs between the file systems
+type FileChange struct {
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+}
+
+// Compares the layers and the configs of the images. With files the
+// layers are downloaded and the file systems of the images compared;
+// the shared layers only once.
+func Diff(ctx context.Context, a, b *Image, files bool) (*ImageDiff, error) {
+	configA, err := a.Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	configB, err := b.Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	layersA, sizeA := a.layerInfos()
+	layersB, sizeB := b.layerInfos()
+	shared := a.sharedLayers(b)
+
+	diff := &ImageDiff{
+		A:            a.Reference(),
+		B:            b.Reference(),
+		SharedLayers: layersA[:shared],
+		OnlyInA:      layersA[shared:],
+		OnlyInB:      layersB[shared:],
+		Config:       diffConfigs(&configA.Config, &configB.Config),
+	}
+	if sizeA != 0 && sizeB != 0 {
+		diff.SizeDelta = sizeB - sizeA
+	}
+
+	if !files {
+		return diff, nil
+	}
+
+	// the shared layers are only read once
+	base := make(map[string]fileEntry)
+	if err := a.applyLayers(ctx, base, a.layers[:shared]); err != nil {
+		return nil, err
+	}
+	filesA, filesB := base, copyFiles(base)
+	if err := a.applyLayers(ctx, filesA, a.layers[shared:]); err != nil {
+		return nil, err
+	}
+	if err := b.applyLayers(ctx, filesB, b.layers[shared:]); err != nil {
+		return nil, err
+	}
+	diff.Files = diffFiles(filesA, filesB)
+
+	return diff, nil
+}
+
+func diffConfigs(a, b *registry.ContainerConfig) []ConfigChange {
+	changes := make([]ConfigChange, 0)
+	changes = append(changes, diffMaps("env", envMap(a.Env), envMap(b.Env))...)
+	changes = append(changes, diffMaps("label", a.Labels, b.Labels)...)
+	changes = appendChange(changes, "entrypoint", "", strings.Join(a.Entrypoint, " "), strings.Join(b.Entrypoint, " "))
+	changes = appendChange(changes, "cmd", "", strings.Join(a.Cmd, " "), strings.Join(b.Cmd, " "))
+	changes = appendChange(changes, "user", "", a.User, b.User)
+	changes = appendChange(changes, "workdir", "", a.WorkingDir, b.WorkingDir)
+	return changes
+}
+
+// the keys of both maps are compared in sorted order
+func diffMaps(field string, a, b map[string]string) []ConfigChange {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, found := a[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := make([]ConfigChange, 0)
+	for _, key := range keys {
+		changes = appendChange(changes, field, key, a[key], b[key])
+	}
+	return changes
+}
+
+// an empty value is treated as not set
+func appendChange(changes []ConfigChange, field, key, a, b string) []ConfigChange {
+	change := ConfigChange{Field: field, Key: key, A: a, B: b}
+	switch {
+	case a == b:
+		return changes
+	case a == "":
+		change.Kind = Added
+	case b == "":
+		change.Kind = Removed
+	default:
+		change.Kind = Modified
+	}
+	return append(changes, change)
+}
+
+// NAME=value entries by name
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, entry := range env {
+		name, value, _ := strings.Cut(entry, "=")
+		m[name] = value
+	}
+	return m
+}
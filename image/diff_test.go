@@ -0,0 +1,106 @@
+package image_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/sojamann/ocapi/image"
+	"github.com/sojamann/ocapi/registry/registrytest"
+)
+
+// a gzipped tarball of the files; names ending with / are directories
+func tarLayer(t *testing.T, files map[string]string) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	archive := tar.NewWriter(gz)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[name]))}
+		if name[len(name)-1] == '/' {
+			header = &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755}
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func resolve(t *testing.T, specifier string) *image.Image {
+	is, err := image.ImageSpecifierParse(context.Background(), specifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgs, err := is.ToImages(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return imgs[0]
+}
+
+func TestDiffFiles(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+
+	base := tarLayer(t, map[string]string{
+		"etc/":      "",
+		"etc/a":     "a",
+		"etc/b":     "b",
+		"etc/c":     "c",
+		"opt/":      "",
+		"opt/old/x": "x",
+	})
+	fake.PushOCIImage("lib/app", "a", nil, base, tarLayer(t, map[string]string{
+		"etc/":       "",
+		"etc/.wh.a":  "",
+		"app/only-a": "1",
+	}))
+	fake.PushOCIImage("lib/app", "b", nil, base, tarLayer(t, map[string]string{
+		"etc/b":            "rewritten",
+		"etc/c":            "c",
+		"opt/.wh..wh..opq": "",
+		"opt/new":          "n",
+	}))
+
+	a := resolve(t, fake.Host()+"/lib/app:a")
+	b := resolve(t, fake.Host()+"/lib/app:b")
+	diff, err := image.Diff(context.Background(), a, b, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []image.FileChange{
+		{Path: "/app/only-a", Kind: image.Removed},
+		// deleted by A, still there in B
+		{Path: "/etc/a", Kind: image.Added},
+		{Path: "/etc/b", Kind: image.Modified},
+		{Path: "/opt/new", Kind: image.Added},
+		// hidden by the opaque directory of B
+		{Path: "/opt/old/x", Kind: image.Removed},
+	}
+	if !reflect.DeepEqual(diff.Files, want) {
+		t.Fatalf("expected %v, got %v", want, diff.Files)
+	}
+	if len(diff.SharedLayers) != 1 || len(diff.OnlyInA) != 1 || len(diff.OnlyInB) != 1 {
+		t.Fatalf("expected one shared layer and one layer on each side, got %+v", diff)
+	}
+}
@@ -0,0 +1,84 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrorPolicy decides what happens when a part of a pattern expansion fails
+type ErrorPolicy int
+
+const (
+	// abort on the first error and cancel all other requests
+	FailFast ErrorPolicy = iota
+	// continue and return all successful results together
+	// with an *ExpansionError listing every failure
+	KeepGoing
+)
+
+type ExpansionFailure struct {
+	// the repository or image that failed
+	Subject string
+	Err     error
+}
+
+// ExpansionError is returned alongside the partial results
+// when expanding with KeepGoing.
+type ExpansionError struct {
+	Failures []ExpansionFailure
+}
+
+func (e *ExpansionError) Error() string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("%d failed:", len(e.Failures)))
+	for _, failure := range e.Failures {
+		builder.WriteString(fmt.Sprintf("\n  %s: %v", failure.Subject, failure.Err))
+	}
+	return builder.String()
+}
+
+func (e *ExpansionError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		errs = append(errs, failure.Err)
+	}
+	return errs
+}
+
+// collects the failures of a fan-out according to the policy
+type failureCollector struct {
+	policy   ErrorPolicy
+	failures []ExpansionFailure
+	firstErr error
+}
+
+func (c *failureCollector) add(subject string, err error) {
+	c.failures = append(c.failures, ExpansionFailure{subject, err})
+
+	// errors caused by cancelling the other requests are not the cause
+	if c.firstErr == nil || errors.Is(c.firstErr, context.Canceled) {
+		c.firstErr = err
+	}
+}
+
+// merges the failures of an *ExpansionError (of a previous step) in
+func (c *failureCollector) merge(err error) {
+	var expErr *ExpansionError
+	if errors.As(err, &expErr) {
+		c.failures = append(c.failures, expErr.Failures...)
+	}
+}
+
+// Returns nil if nothing failed, the first error for FailFast and
+// an *ExpansionError for KeepGoing.
+func (c *failureCollector) err() error {
+	if len(c.failures) == 0 {
+		return nil
+	}
+	if c.policy == FailFast {
+		return c.firstErr
+	}
+	return &ExpansionError{c.failures}
+}
@@ -0,0 +1,195 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	// the directory only contains the entries of this and the upper layers
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// the metadata and content digest of a path
+type fileEntry struct {
+	typeflag byte
+	mode     int64
+	uid      int
+	gid      int
+	size     int64
+	linkname string
+	// sha256 of the content of regular files
+	digest string
+}
+
+// Downloads the layers and applies them in order to the
+// file system given as entries by path
+func (image *Image) applyLayers(ctx context.Context, files map[string]fileEntry, layers []string) error {
+	if len(layers) == 0 {
+		return nil
+	}
+	if image.source == nil {
+		return fmt.Errorf("%s: the registry of the image is unknown", image.Reference())
+	}
+
+	for _, layer := range layers {
+		blob, err := image.source.GetBlob(ctx, image.name, layer)
+		if err != nil {
+			return err
+		}
+
+		err = applyLayer(files, blob)
+		// the digest is only verified when the blob is read to the end
+		if err == nil {
+			_, err = io.Copy(io.Discard, blob)
+		}
+		blob.Close()
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", layer, err)
+		}
+	}
+
+	return nil
+}
+
+// Applies the entries and whiteouts of the (compressed) layer tarball.
+// Whiteouts only hide the entries of the lower layers.
+func applyLayer(files map[string]fileEntry, layer io.Reader) error {
+	reader, err := decompress(layer)
+	if err != nil {
+		return err
+	}
+
+	// the entries of this layer
+	added := make(map[string]bool)
+
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean("/" + header.Name)
+		if name == "/" {
+			continue
+		}
+		dir, base := path.Split(name)
+
+		switch {
+		case base == opaqueWhiteout:
+			deleteChildren(files, path.Clean(dir), added)
+
+		case strings.HasPrefix(base, whiteoutPrefix):
+			deleted := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			deleteChildren(files, deleted, added)
+			delete(files, deleted)
+
+		default:
+			entry := fileEntry{
+				typeflag: header.Typeflag,
+				mode:     header.Mode,
+				uid:      header.Uid,
+				gid:      header.Gid,
+				size:     header.Size,
+				linkname: header.Linkname,
+			}
+			if header.Typeflag == tar.TypeReg {
+				hash := sha256.New()
+				if _, err := io.Copy(hash, archive); err != nil {
+					return err
+				}
+				entry.digest = hex.EncodeToString(hash.Sum(nil))
+			}
+			files[name] = entry
+			added[name] = true
+		}
+	}
+}
+
+// removes everything below dir except the entries to keep
+func deleteChildren(files map[string]fileEntry, dir string, keep map[string]bool) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for name := range files {
+		if strings.HasPrefix(name, prefix) && !keep[name] {
+			delete(files, name)
+		}
+	}
+}
+
+// Layers are gzip compressed or plain tarballs
+func decompress(layer io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(layer)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return nil, errors.New("zstd compressed layers are not supported")
+	default:
+		return buffered, nil
+	}
+}
+
+// Compares the file systems of A and B given as entries by path
+func diffFiles(a, b map[string]fileEntry) []FileChange {
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, found := a[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := make([]FileChange, 0)
+	for _, name := range names {
+		entryA, inA := a[name]
+		entryB, inB := b[name]
+
+		switch {
+		case !inB:
+			changes = append(changes, FileChange{Path: name, Kind: Removed})
+		case !inA:
+			changes = append(changes, FileChange{Path: name, Kind: Added})
+		case entryA != entryB:
+			changes = append(changes, FileChange{Path: name, Kind: Modified})
+		}
+	}
+	return changes
+}
+
+// returns a copy of the file system
+func copyFiles(files map[string]fileEntry) map[string]fileEntry {
+	copied := make(map[string]fileEntry, len(files))
+	for name, entry := range files {
+		copied[name] = entry
+	}
+	return copied
+}
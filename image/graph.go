@@ -0,0 +1,90 @@
+package image
+
+import (
+	"sort"
+)
+
+// GraphNode is an image of a graph together with the
+// images that have it as their closest parent
+type GraphNode struct {
+	Reference string `json:"reference"`
+	// the name with the tag but without the digest if the image is tagged
+	Name string `json:"name"`
+	// os/arch[/variant]; empty if unknown
+	Platform string `json:"platform,omitempty"`
+	// the number of layers
+	Layers   int          `json:"layers"`
+	Children []*GraphNode `json:"children,omitempty"`
+
+	image *Image
+}
+
+func (node *GraphNode) Image() *Image {
+	return node.image
+}
+
+// Arranges the images as a forest: every image becomes a child of its
+// closest parent, the image with the most layers among the ones whose
+// layers are a strict prefix of its own. Images without a parent are
+// the returned roots. Roots and children are sorted by reference.
+//
+// base     = [a]
+// runtime  = [a, b]
+// app      = [a, b, c]   child of runtime, not of base
+func Graph(images []*Image) []*GraphNode {
+	nodes := make([]*GraphNode, 0, len(images))
+	for _, img := range images {
+		node := &GraphNode{
+			Reference: img.Reference(),
+			Name:      img.FullyQualifiedName(),
+			Layers:    len(img.layers),
+			image:     img,
+		}
+		if img.platform != nil {
+			node.Platform = img.platform.String()
+		}
+		nodes = append(nodes, node)
+	}
+	sortNodes(nodes)
+
+	roots := make([]*GraphNode, 0)
+	for _, child := range nodes {
+		parent := closestParent(child, nodes)
+		if parent == nil {
+			roots = append(roots, child)
+			continue
+		}
+		// nodes are sorted so the children are as well
+		parent.Children = append(parent.Children, child)
+	}
+
+	return roots
+}
+
+// Returns nil if no image is a strict parent of the child. Of multiple
+// candidates with the same number of layers the first one wins.
+func closestParent(child *GraphNode, nodes []*GraphNode) *GraphNode {
+	var closest *GraphNode
+	for _, node := range nodes {
+		// an image without layers would be the parent of every image
+		if node.Layers == 0 || node.Layers >= child.Layers {
+			continue
+		}
+		if closest != nil && node.Layers <= closest.Layers {
+			continue
+		}
+		if node.image.IsParentOf(child.image) {
+			closest = node
+		}
+	}
+	return closest
+}
+
+func sortNodes(nodes []*GraphNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].Reference != nodes[j].Reference {
+			return nodes[i].Reference < nodes[j].Reference
+		}
+		return nodes[i].Platform < nodes[j].Platform
+	})
+}
@@ -0,0 +1,41 @@
+package image
+
+import (
+	"testing"
+)
+
+func namedImage(name string, layers ...string) *Image {
+	img := imageWithLayers(layers...)
+	img.name = name
+	return img
+}
+
+func TestGraphClosestParent(t *testing.T) {
+	roots := Graph([]*Image{
+		namedImage("app", "a", "b", "c"),
+		namedImage("os", "a"),
+		namedImage("runtime", "a", "b"),
+		namedImage("other", "x"),
+		namedImage("empty"),
+	})
+
+	// roots sorted by reference: empty, os, other
+	if len(roots) != 3 || roots[1].image.name != "os" {
+		t.Fatalf("expected the roots empty, os and other, got %d roots", len(roots))
+	}
+	if roots[1].Name != "registry.example.com/os:1" {
+		t.Fatalf("expected the name to be the tagged name, got %s", roots[1].Name)
+	}
+	if len(roots[0].Children) != 0 {
+		t.Fatal("expected an image without layers to be no parent")
+	}
+
+	os := roots[1]
+	if len(os.Children) != 1 || os.Children[0].image.name != "runtime" {
+		t.Fatalf("expected runtime to be the only child of os, got %v", os.Children)
+	}
+	runtime := os.Children[0]
+	if len(runtime.Children) != 1 || runtime.Children[0].image.name != "app" {
+		t.Fatalf("expected app to be the child of runtime, got %v", runtime.Children)
+	}
+}
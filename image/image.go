@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -11,27 +12,94 @@ type Image struct {
 	registryHost string
 	name         string
 	tag          string
-	architecture string
-	layers       []string
+	digest       string
+	mediaType    string
+	// nil if the platform is unknown
+	platform *registry.Platform
+	// layer digests with the base layer first
+	layers      []string
+	annotations map[string]string
+
+	manifest *registry.Manifest
+	// the registry the image was fetched from; nil if
+	// the image was only built from a manifest
+	source *registry.Registry
+	// nil until fetched by Config
+	config *registry.ImageConfig
 }
 
-func ImageFromManifest(registryHost string, mp *registry.Manifest) *Image {
-	layers := make([]string, 0, len(mp.FsLayers))
+// Builds the image from any of the supported (non index) manifest formats.
+// The name and tag are taken from the manifest if it carries them
+// (schema1) and the given ones are used as fallback.
+func ImageFromManifest(registryHost, name, tag string, mp *registry.Manifest) *Image {
+	if mp.Name != "" {
+		name = mp.Name
+	}
+	if mp.Tag != "" {
+		tag = mp.Tag
+	}
 
-	for _, layer := range mp.FsLayers {
-		layers = append(layers, layer.BlobSum)
+	var platform *registry.Platform
+	if mp.Architecture != "" {
+		platform = &registry.Platform{Architecture: mp.Architecture}
 	}
+
 	return &Image{
 		registryHost: registryHost,
-		name:         mp.Name,
-		tag:          mp.Tag,
-		architecture: mp.Architecture,
-		layers:       layers,
+		name:         name,
+		tag:          tag,
+		digest:       mp.Digest,
+		mediaType:    mp.MediaType,
+		platform:     platform,
+		layers:       mp.LayerDigests(),
+		annotations:  mp.Annotations,
+		manifest:     mp,
 	}
 }
 
+// Returns the platform of the image or nil if unknown
+func (image *Image) Platform() *registry.Platform {
+	return image.platform
+}
+
 func (image *Image) FullyQualifiedName() string {
-	return fmt.Sprintf("%s/%s:%s", image.registryHost, image.name, image.tag)
+	if image.tag == "" {
+		return image.Reference()
+	}
+	return formatReference(image.registryHost, image.name, image.tag, "")
+}
+
+// Returns the name including the tag and the digest
+func (image *Image) Reference() string {
+	return formatReference(image.registryHost, image.name, image.tag, image.digest)
+}
+
+func (image *Image) Digest() string {
+	return image.digest
+}
+
+// Returns the manifest the image was built from
+func (image *Image) Manifest() *registry.Manifest {
+	return image.manifest
+}
+
+// Returns the config (env, cmd, labels, ...) of the image.
+// It is fetched from the registry on first use.
+func (image *Image) Config(ctx context.Context) (*registry.ImageConfig, error) {
+	if image.config != nil {
+		return image.config, nil
+	}
+	if image.source == nil {
+		return nil, fmt.Errorf("%s: the registry of the image is unknown", image.Reference())
+	}
+
+	config, err := image.source.GetImageConfig(ctx, image.name, image.manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	image.config = config
+	return config, nil
 }
 
 // For this function to return true parent must be a true base image
@@ -42,22 +110,38 @@ func (image *Image) IsParentOf(child *Image) bool {
 		return false
 	}
 
-	offset := len(child.layers) - len(image.layers)
+	return image.sharedLayers(child) == len(image.layers)
+}
 
-	for i := len(image.layers) - 1; i >= 0; i-- {
-		// add offset as child has more layers
-		cLayer := child.layers[i+offset]
-		pLayer := image.layers[i]
-		if pLayer != cLayer {
-			return false
-		}
+// Returns the number of layers both images start with
+func (image *Image) sharedLayers(other *Image) int {
+	n := 0
+	for n < len(image.layers) && n < len(other.layers) && image.layers[n] == other.layers[n] {
+		n++
 	}
-
-	return true
+	return n
 }
 
 func (image *Image) String() string {
-	width := len(image.layers[0])
+	title := image.name
+	if image.tag != "" {
+		title += ":" + image.tag
+	}
+	if image.platform != nil {
+		title += " " + image.platform.String()
+	}
+	title = fmt.Sprintf("[ %s ]", title)
+
+	// a manifest may have no layers to take the width from
+	var width int
+	if len(image.layers) > 0 {
+		width = len(image.layers[0])
+	} else {
+		width = len(title)
+		if len(image.digest) > width {
+			width = len(image.digest)
+		}
+	}
 	center := func(s string) string {
 		return fmt.Sprintf("%*s", -width, fmt.Sprintf("%*s", (width+len(s))/2, s))
 	}
@@ -68,9 +152,15 @@ func (image *Image) String() string {
 
 	builder.WriteString(seperator)
 	builder.WriteString("| ")
-	builder.WriteString(center(fmt.Sprintf("[ %s ]", image.name+":"+image.tag)))
+	builder.WriteString(center(title))
 	builder.WriteString(" |")
 	builder.WriteRune('\n')
+	if image.digest != "" {
+		builder.WriteString("| ")
+		builder.WriteString(center(image.digest))
+		builder.WriteString(" |")
+		builder.WriteRune('\n')
+	}
 	builder.WriteString(seperator)
 
 	for _, layer := range image.layers {
@@ -79,6 +169,8 @@ func (image *Image) String() string {
 		builder.WriteString(" |")
 		builder.WriteRune('\n')
 	}
-	builder.WriteString(seperator)
+	if len(image.layers) > 0 {
+		builder.WriteString(seperator)
+	}
 	return builder.String()
 }
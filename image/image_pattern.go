@@ -1,6 +1,8 @@
 package image
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -10,8 +12,6 @@ import (
 	"github.com/sojamann/ocapi/registry"
 )
 
-const numConcurrentTasks = 5
-
 const registryPattern = `[\w.-_]+`
 const imagePattern = `(\*|([\w-_./]+)(\*|\/)?)`
 const tagPattern = `(\*|[\w-_.]+)`
@@ -41,23 +41,25 @@ func (s *ImagePattern) IsValid() bool {
 // It is a glob if the tag is given as asterix (*)
 // It is a glob if the name ends with /*
 // It is a glob if no tag is given
-// TODO: this is sequential as of now
-func (s *ImagePattern) ExpandToSpecifiers() ([]ImageSpecifier, error) {
+// With FailFast the first failing tag lookup cancels all other ones,
+// with KeepGoing the specifiers of all other repositories are returned
+// together with an *ExpansionError.
+func (s *ImagePattern) ExpandToSpecifiers(ctx context.Context, policy ErrorPolicy) ([]ImageSpecifier, error) {
 	// TODO: maybe return an error instead of a panic??
 	if !s.IsValid() {
 		panic("Expanding non validated ImagePattern is not okay .....")
 	}
 
 	log.Debug().Str("pattern", string(*s)).Msg("expanding image pattern")
-	registryHost, imageSpecifier, tagSpecifier := parseParts(string(*s))
+	registryHost, imageSpecifier, tagSpecifier, _ := parseParts(string(*s))
 
-	r, err := registry.NewRegisty(registryHost)
+	r, err := registry.NewRegisty(ctx, registryHost)
 	if err != nil {
 		return nil, err
 	}
 
 	// resolve imageSpecifier
-	matchingImageNames, err := expandImageSpecifier(r, imageSpecifier)
+	matchingImageNames, err := expandImageSpecifier(ctx, r, imageSpecifier)
 	if err != nil {
 		return nil, err
 	}
@@ -68,48 +70,94 @@ func (s *ImagePattern) ExpandToSpecifiers() ([]ImageSpecifier, error) {
 		is  []ImageSpecifier
 		err error
 	}
-	tagResults := slices.MapAsync(matchingImageNames, numConcurrentTasks, func(image string) result {
-		is, err := expandTagSpecifier(r, image, tagSpecifier)
-		bar.Add(1)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tagResults := mapConcurrent(r, matchingImageNames, func(image string) result {
+		defer bar.Add(1)
+		if ctx.Err() != nil {
+			return result{nil, ctx.Err()}
+		}
+		is, err := expandTagSpecifier(ctx, r, image, tagSpecifier)
+		if err != nil && policy == FailFast {
+			cancel()
+		}
 		return result{is, err}
 	})
 
+	failures := failureCollector{policy: policy}
 	imageSpecifiers := make([]ImageSpecifier, 0, len(matchingImageNames))
-	for _, result := range tagResults {
+	for i, result := range tagResults {
 		if result.err != nil {
-			return nil, err
+			failures.add(formatReference(r.Host, matchingImageNames[i], "", ""), result.err)
+			continue
 		}
 		imageSpecifiers = append(imageSpecifiers, result.is...)
 	}
 
+	if err := failures.err(); err != nil {
+		if policy == FailFast {
+			return nil, err
+		}
+		return imageSpecifiers, err
+	}
+
 	return imageSpecifiers, nil
 }
 
-func (s *ImagePattern) ExpandToImages() ([]*Image, error) {
-	specifiers, err := s.ExpandToSpecifiers()
-	if err != nil {
+// Expands the pattern and fetches the images. Indexes are resolved
+// to the image for the given platform or to all of them if nil.
+// See ExpandToSpecifiers for how errors are handled.
+func (s *ImagePattern) ExpandToImages(ctx context.Context, platform *registry.Platform, policy ErrorPolicy) ([]*Image, error) {
+	failures := failureCollector{policy: policy}
+
+	specifiers, err := s.ExpandToSpecifiers(ctx, policy)
+	// only the failures of parts of the pattern can be skipped, not
+	// e.g. an unreachable registry or a denied catalog
+	var expErr *ExpansionError
+	if err != nil && (policy == FailFast || !errors.As(err, &expErr)) {
 		return nil, err
 	}
+	failures.merge(err)
+
+	if len(specifiers) == 0 {
+		return []*Image{}, failures.err()
+	}
 
-	bar := pbar("Getting image tags", len(specifiers))
+	bar := pbar("Getting images", len(specifiers))
 	defer bar.Clear()
 	type result struct {
-		img *Image
-		err error
+		imgs []*Image
+		err  error
 	}
-	imageGetResult := slices.MapAsync(specifiers, numConcurrentTasks, func(sp ImageSpecifier) result {
-		img, err := sp.ToImage()
-		bar.Add(1)
-		return result{img, err}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// all specifiers of a pattern share the registry
+	imageGetResult := mapConcurrent(specifiers[0].Registry, specifiers, func(sp ImageSpecifier) result {
+		defer bar.Add(1)
+		if ctx.Err() != nil {
+			return result{nil, ctx.Err()}
+		}
+		imgs, err := sp.ToImages(ctx, platform)
+		if err != nil && policy == FailFast {
+			cancel()
+		}
+		return result{imgs, err}
 	})
 
 	images := make([]*Image, 0, len(specifiers))
-	for _, result := range imageGetResult {
+	for i, result := range imageGetResult {
 		if result.err != nil {
-			return nil, result.err
+			failures.add(specifiers[i].String(), result.err)
+			continue
 		}
+		images = append(images, result.imgs...)
+	}
 
-		images = append(images, result.img)
+	if err := failures.err(); err != nil {
+		if policy == FailFast {
+			return nil, err
+		}
+		return images, err
 	}
 
 	return images, nil
@@ -120,7 +168,7 @@ func (s *ImagePattern) ExpandToImages() ([]*Image, error) {
 // all images below this path: some/image/*
 // all images below this path: some/image/
 // full image path no need to expand: some/image/image
-func expandImageSpecifier(r *registry.Registry, imageName string) ([]string, error) {
+func expandImageSpecifier(ctx context.Context, r *registry.Registry, imageName string) ([]string, error) {
 	log.Debug().Str("host", r.Host).Str("image", imageName).Msg("expanding image name")
 
 	images := make([]string, 0, 1)
@@ -131,24 +179,29 @@ func expandImageSpecifier(r *registry.Registry, imageName string) ([]string, err
 		return images, nil
 	}
 
-	images, err := r.GetCatalog()
+	// filter page by page so that huge catalogs are never held in
+	// memory (only catalogs small enough to be cached are)
+	matcher := regexp.MustCompile("^" + regexp.QuoteMeta(strings.TrimRight(imageName, "*")) + ".*")
+	err := r.WalkCatalog(ctx, func(page []string) error {
+		images = append(images, slices.Filter(page, matcher.MatchString)...)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	matcher := regexp.MustCompile("^" + strings.TrimRight(imageName, "*") + ".*")
-	return slices.Filter(images, matcher.MatchString), nil
+	return images, nil
 }
 
-func expandTagSpecifier(r *registry.Registry, image string, tagName string) ([]ImageSpecifier, error) {
+func expandTagSpecifier(ctx context.Context, r *registry.Registry, image string, tagName string) ([]ImageSpecifier, error) {
 	log.Debug().Str("host", r.Host).Str("image", image).Str("tag", tagName).Msg("expanding tag")
 	imageSpecifiers := make([]ImageSpecifier, 0, 1)
 
 	// when the tag is specified add the tag to all images but make sure
 	// that the tag exists for the image
 	if tagName != "*" {
-		is := ImageSpecifier{r, image, tagName}
-		exists, err := is.Exists()
+		is := ImageSpecifier{Registry: r, ImageName: image, Tag: tagName}
+		exists, err := is.Exists(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -159,13 +212,13 @@ func expandTagSpecifier(r *registry.Registry, image string, tagName string) ([]I
 		return imageSpecifiers, nil
 	}
 
-	tags, err := r.GetTags(image)
+	tags, err := r.GetTags(ctx, image)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, tag := range tags {
-		imageSpecifiers = append(imageSpecifiers, ImageSpecifier{r, image, tag})
+		imageSpecifiers = append(imageSpecifiers, ImageSpecifier{Registry: r, ImageName: image, Tag: tag})
 	}
 
 	return imageSpecifiers, nil
@@ -0,0 +1,49 @@
+package image_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/sojamann/ocapi/image"
+	"github.com/sojamann/ocapi/registry/registrytest"
+)
+
+func TestExpandToImagesUnreachableRegistry(t *testing.T) {
+	pattern := image.ImagePattern("localhost:1/*:*")
+
+	for _, policy := range []image.ErrorPolicy{image.FailFast, image.KeepGoing} {
+		imgs, err := pattern.ExpandToImages(context.Background(), nil, policy)
+		if err == nil {
+			t.Fatalf("policy %d: expected an error, got %d images", policy, len(imgs))
+		}
+		var expErr *image.ExpansionError
+		if errors.As(err, &expErr) {
+			t.Fatalf("policy %d: expected the connection error, got %v", policy, err)
+		}
+	}
+}
+
+func TestExpandToImagesKeepGoing(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.PushOCIImage("lib/ok", "1", nil, []byte("a"))
+	fake.PushOCIImage("lib/broken", "1", nil, []byte("b"))
+	fake.InjectFault(registrytest.Fault{PathPrefix: "/v2/lib/broken/tags", Status: http.StatusNotFound})
+
+	pattern := image.ImagePattern(fake.Host() + "/lib/*:*")
+	imgs, err := pattern.ExpandToImages(context.Background(), nil, image.KeepGoing)
+
+	var expErr *image.ExpansionError
+	if !errors.As(err, &expErr) || len(expErr.Failures) != 1 {
+		t.Fatalf("expected one failed repository, got %v", err)
+	}
+	if len(imgs) != 1 || imgs[0].FullyQualifiedName() != fake.Host()+"/lib/ok:1" {
+		t.Fatalf("expected the image of lib/ok, got %v", imgs)
+	}
+
+	if _, err := pattern.ExpandToImages(context.Background(), nil, image.FailFast); err == nil {
+		t.Fatal("expected FailFast to fail")
+	}
+}
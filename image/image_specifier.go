@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 
@@ -10,10 +11,13 @@ import (
 type ImageSpecifier struct {
 	Registry  *registry.Registry
 	ImageName string
-	Tag       string
+	// empty if the image is only referenced by digest
+	Tag string
+	// if set the image is pinned to this digest
+	Digest string
 }
 
-var imageSpecifierRe = regexp.MustCompile(`^[\w.-_]+\/([\w-_.]+\/)*[\w-_.]+:[\w-_.]+$`)
+var imageSpecifierRe = regexp.MustCompile(`^[\w.-_]+\/([\w-_.]+\/)*[\w-_.]+(:[\w-_.]+|@sha(256|512):[a-f0-9]+|:[\w-_.]+@sha(256|512):[a-f0-9]+)$`)
 
 type InvalidImageSpecifier string
 
@@ -28,14 +32,14 @@ func ValidateImageSpecifier(s string) error {
 	return InvalidImageSpecifier(s)
 }
 
-func ImageSpecifierParse(s string) (*ImageSpecifier, error) {
+func ImageSpecifierParse(ctx context.Context, s string) (*ImageSpecifier, error) {
 	if !imageSpecifierRe.MatchString(s) {
 		return nil, InvalidImageSpecifier(s)
 	}
 
-	registryHost, imageName, tag := parseParts(s)
+	registryHost, imageName, tag, digest := parseParts(s)
 
-	r, err := registry.NewRegisty(registryHost)
+	r, err := registry.NewRegisty(ctx, registryHost)
 	if err != nil {
 		return nil, err
 	}
@@ -44,22 +48,78 @@ func ImageSpecifierParse(s string) (*ImageSpecifier, error) {
 		Registry:  r,
 		ImageName: imageName,
 		Tag:       tag,
+		Digest:    digest,
 	}, nil
 }
 
-func (is *ImageSpecifier) Exists() (bool, error) {
-	return is.Registry.Exists(is.ImageName, is.Tag)
+// the digest if pinned else the tag
+func (is *ImageSpecifier) reference() string {
+	if is.Digest != "" {
+		return is.Digest
+	}
+	return is.Tag
+}
+
+func (is *ImageSpecifier) Exists(ctx context.Context) (bool, error) {
+	return is.Registry.Exists(ctx, is.ImageName, is.reference())
 }
 
-func (is *ImageSpecifier) ToImage() (*Image, error) {
-	manifest, err := is.Registry.GetManifest(is.ImageName, is.Tag)
+// Returns the manifest the specifier points to; an index
+// for images of multiple platforms
+func (is *ImageSpecifier) Manifest(ctx context.Context) (*registry.Manifest, error) {
+	return is.Registry.GetManifest(ctx, is.ImageName, is.reference())
+}
+
+// Returns the images this specifier refers to. This is exactly one image
+// unless the tag points to an index, in which case an image per platform
+// matching the given platform is returned (all if platform is nil).
+// A single image is only returned if its config matches the platform.
+// Without a matching platform the result contains no images.
+func (is *ImageSpecifier) ToImages(ctx context.Context, platform *registry.Platform) ([]*Image, error) {
+	manifest, err := is.Manifest(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return ImageFromManifest(is.Registry.Host, manifest), nil
+	if !manifest.IsIndex() {
+		img := ImageFromManifest(is.Registry.Host, is.ImageName, is.Tag, manifest)
+		img.source = is.Registry
+		if platform == nil {
+			return []*Image{img}, nil
+		}
+
+		// only an index lists the platforms, a single image has its own in the config
+		config, err := img.Config(ctx)
+		if err != nil {
+			return nil, err
+		}
+		img.platform = &registry.Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+		if !img.platform.Matches(platform) {
+			return []*Image{}, nil
+		}
+		return []*Image{img}, nil
+	}
+
+	descriptors := manifest.PlatformManifests(platform)
+	images := make([]*Image, 0, len(descriptors))
+	for _, desc := range descriptors {
+		child, err := is.Registry.GetManifest(ctx, is.ImageName, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if child.IsIndex() {
+			return nil, fmt.Errorf("%s: nested indexes are not supported", is)
+		}
+
+		img := ImageFromManifest(is.Registry.Host, is.ImageName, is.Tag, child)
+		img.platform = desc.Platform
+		img.source = is.Registry
+		images = append(images, img)
+	}
+
+	return images, nil
 }
 
 func (is ImageSpecifier) String() string {
-	return fmt.Sprintf("%s/%s:%s", is.Registry.Host, is.ImageName, is.Tag)
+	return formatReference(is.Registry.Host, is.ImageName, is.Tag, is.Digest)
 }
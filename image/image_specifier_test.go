@@ -0,0 +1,77 @@
+package image_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sojamann/ocapi/image"
+	"github.com/sojamann/ocapi/registry"
+	"github.com/sojamann/ocapi/registry/registrytest"
+)
+
+func TestToImagesResolvesIndex(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	amd64 := fake.PushOCIImage("lib/app", "", registrytest.Config("linux", "amd64"), []byte("amd64"))
+	arm64 := fake.PushOCIImage("lib/app", "", registrytest.Config("linux", "arm64"), []byte("arm64"))
+	fake.PushIndex("lib/app", "1", registry.MediaTypeDockerManifestList,
+		registrytest.IndexEntry{Digest: amd64, Platform: registry.Platform{OS: "linux", Architecture: "amd64"}},
+		registrytest.IndexEntry{Digest: arm64, Platform: registry.Platform{OS: "linux", Architecture: "arm64"}},
+	)
+
+	specifier, err := image.ImageSpecifierParse(context.Background(), fake.Host()+"/lib/app:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := specifier.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.IsIndex() {
+		t.Fatalf("expected the index the tag points to, got %s", manifest.MediaType)
+	}
+
+	imgs, err := specifier.ToImages(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 2 {
+		t.Fatalf("expected an image per platform, got %d", len(imgs))
+	}
+
+	imgs, err = specifier.ToImages(context.Background(), &registry.Platform{OS: "linux", Architecture: "arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 || imgs[0].Digest() != arm64 || imgs[0].Platform().Architecture != "arm64" {
+		t.Fatalf("expected the arm64 image, got %v", imgs)
+	}
+}
+
+func TestToImagesFiltersImageByPlatform(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.PushOCIImage("lib/app", "1", registrytest.Config("linux", "amd64"), []byte("amd64"))
+
+	specifier, err := image.ImageSpecifierParse(context.Background(), fake.Host()+"/lib/app:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imgs, err := specifier.ToImages(context.Background(), &registry.Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 || imgs[0].Platform().String() != "linux/amd64" {
+		t.Fatalf("expected the amd64 image, got %v", imgs)
+	}
+
+	imgs, err = specifier.ToImages(context.Background(), &registry.Platform{OS: "linux", Architecture: "arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 0 {
+		t.Fatalf("expected no image for another platform, got %d", len(imgs))
+	}
+}
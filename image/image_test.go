@@ -0,0 +1,48 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/sojamann/ocapi/registry"
+)
+
+func imageWithLayers(layers ...string) *Image {
+	manifest := &registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest}
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, registry.Descriptor{Digest: layer})
+	}
+	return ImageFromManifest("registry.example.com", "lib/app", "1", manifest)
+}
+
+func TestIsParentOf(t *testing.T) {
+	base := imageWithLayers("a", "b")
+
+	tests := []struct {
+		child *Image
+		want  bool
+	}{
+		{imageWithLayers("a", "b", "c"), true},
+		{imageWithLayers("a", "b"), true},
+		{imageWithLayers("a"), false},
+		{imageWithLayers("a", "c", "b"), false},
+		{imageWithLayers("b", "a", "c"), false},
+	}
+
+	for _, test := range tests {
+		if got := base.IsParentOf(test.child); got != test.want {
+			t.Errorf("%v: expected %v, got %v", test.child.layers, test.want, got)
+		}
+	}
+}
+
+func TestStringWithoutLayers(t *testing.T) {
+	got := imageWithLayers().String()
+
+	want := "" +
+		"-----------------\n" +
+		"| [ lib/app:1 ] |\n" +
+		"-----------------\n"
+	if got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
@@ -0,0 +1,101 @@
+package image
+
+import (
+	"github.com/sojamann/ocapi/registry"
+)
+
+// The serializable forms of specifiers and images used for the
+// machine readable output. Fields are only ever added to keep
+// consumers working.
+
+// ImageSpecifierInfo describes an ImageSpecifier
+type ImageSpecifierInfo struct {
+	Registry string `json:"registry"`
+	Name     string `json:"name"`
+	Tag      string `json:"tag,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+	// registry/name[:tag][@digest]
+	Reference string `json:"reference"`
+}
+
+// ImageInfo describes an Image
+type ImageInfo struct {
+	Registry  string `json:"registry"`
+	Name      string `json:"name"`
+	Tag       string `json:"tag,omitempty"`
+	Digest    string `json:"digest"`
+	Reference string `json:"reference"`
+	MediaType string `json:"mediaType"`
+	// os/arch[/variant]; empty if unknown
+	Platform string `json:"platform,omitempty"`
+	// compressed size of the layers; 0 if unknown (schema1)
+	Size int64 `json:"size,omitempty"`
+	// the layers with the base layer first
+	Layers      []LayerInfo       `json:"layers"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// only set if the config was fetched
+	Config *registry.ImageConfig `json:"config,omitempty"`
+}
+
+type LayerInfo struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+}
+
+func (is ImageSpecifier) Info() ImageSpecifierInfo {
+	return ImageSpecifierInfo{
+		Registry:  is.Registry.Host,
+		Name:      is.ImageName,
+		Tag:       is.Tag,
+		Digest:    is.Digest,
+		Reference: is.String(),
+	}
+}
+
+// The config is included if it was fetched before (see Config)
+func (image *Image) Info() ImageInfo {
+	info := ImageInfo{
+		Registry:    image.registryHost,
+		Name:        image.name,
+		Tag:         image.tag,
+		Digest:      image.digest,
+		Reference:   image.Reference(),
+		MediaType:   image.mediaType,
+		Annotations: image.annotations,
+		Config:      image.config,
+	}
+	if image.platform != nil {
+		info.Platform = image.platform.String()
+	} else if image.config != nil {
+		platform := registry.Platform{OS: image.config.OS, Architecture: image.config.Architecture, Variant: image.config.Variant}
+		info.Platform = platform.String()
+	}
+
+	info.Layers, info.Size = image.layerInfos()
+	return info
+}
+
+// Returns the layers with the base layer first and their total size
+func (image *Image) layerInfos() ([]LayerInfo, int64) {
+	layers := make([]LayerInfo, 0, len(image.layers))
+
+	// schema1 manifests only know the digests of their layers
+	if image.manifest == nil || image.manifest.IsSchema1() {
+		for _, layer := range image.layers {
+			layers = append(layers, LayerInfo{Digest: layer})
+		}
+		return layers, 0
+	}
+
+	var size int64
+	for _, layer := range image.manifest.Layers {
+		size += layer.Size
+		layers = append(layers, LayerInfo{
+			Digest:    layer.Digest,
+			MediaType: layer.MediaType,
+			Size:      layer.Size,
+		})
+	}
+	return layers, size
+}
@@ -3,18 +3,66 @@ package image
 import (
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	progressbar "github.com/schollz/progressbar/v3"
+	"github.com/sojamann/ocapi/registry"
 )
 
-// All images MUST specified as registry.com/namespace/image:tag
-func parseParts(name string) (string, string, string) {
+// All images MUST specified as registry.com/namespace/image:tag,
+// registry.com/namespace/image@digest or registry.com/namespace/image:tag@digest
+func parseParts(name string) (string, string, string, string) {
 	// TODO: dont ignore anything here!!
 	registry, rest, _ := strings.Cut(name, "/")
+	rest, digest, _ := strings.Cut(rest, "@")
 	image, tag, _ := strings.Cut(rest, ":")
 
-	return registry, image, tag
+	return registry, image, tag, digest
+}
+
+// the inverse of parseParts
+func formatReference(registry, image, tag, digest string) string {
+	ref := registry + "/" + image
+	if tag != "" {
+		ref += ":" + tag
+	}
+	if digest != "" {
+		ref += "@" + digest
+	}
+	return ref
+}
+
+// Calls fn for every item in parallel and returns the results in the
+// order of the items. The number of workers is taken from the registry
+// as it is the single place where requests are throttled.
+func mapConcurrent[T, R any](r *registry.Registry, items []T, fn func(T) R) []R {
+	results := make([]R, len(items))
+
+	workers := r.Concurrency()
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = fn(items[i])
+			}
+		}()
+	}
+
+	for i := range items {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
 }
 
 // Returns a new progressbar (a slightly modified progressbar.Default)
@@ -1,122 +1,444 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 type tokenResponse struct {
-	Token    string `json:"token"`
-	Scope    string `json:"scope"`
-	Validity int    `json:"expires_in"`
-	Issued   string `json:"issued_at"`
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	Scope       string `json:"scope"`
+	Validity    int    `json:"expires_in"`
+	Issued      string `json:"issued_at"`
+	// only returned by the OAuth2 flow
+	RefreshToken string `json:"refresh_token"`
 }
 
 type token struct {
 	token      string
 	scope      string
 	validUntil time.Time
+	// not persisted, see setIdentityToken
+	refreshToken string
+}
+
+// the scope to list the repositories of a registry
+const catalogScope = "registry:catalog:*"
+
+// Returns the scope for the actions (pull, push, ...) on the repository
+func repositoryScope(repo string, actions ...string) string {
+	repo = strings.TrimPrefix(repo, "/")
+	repo = strings.TrimSuffix(repo, "/")
+	return "repository:" + repo + ":" + strings.Join(actions, ",")
 }
 
 type authorizer interface {
-	// authorizes this request by making it
-	// and authenticating at the desired endpoint
-	authorizeRequest(*http.Request) error
-	// authorizes this request by optaining a registy
-	// pull token. This uses the caching mechanism
-	authorizeRepoPull(*http.Request, string) error
+	// authorizes the request for the scopes (see repositoryScope).
+	// Without scopes the request is authorized for the registry only.
+	authorize(req *http.Request, scopes ...string) error
+	// authorizes the request again after the registry rejected it with
+	// the challenge (Www-Authenticate header) which may ask for more scopes
+	reauthorize(req *http.Request, challenge string) error
 }
 
-// TODO: make a on demand oauth authorizer
-//			which asks the user for password
-type oAuthAuthorizer struct {
-	authEndpoint   string
-	service        string
-	credentials    credentials
-	repoPullTokens map[string]*token
-	mutex          sync.Mutex
+// Picks the authorizer matching the challenge (Www-Authenticate header)
+// the registry responded with. An empty challenge means that the
+// registry can be accessed anonymously. creds may be nil, credsHost is
+// the name they were found under (the host or one of its aliases).
+func authorizerFromChallenge(ctx context.Context, host, challenge string, creds *credentials, credsHost string, client *http.Client) (authorizer, error) {
+	if challenge == "" {
+		return &anonymousAuthorizer{host: host, credentials: creds, credentialsHost: credsHost, client: client}, nil
+	}
+
+	scheme, _ := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds == nil {
+			creds = promptCredentials(host, func(creds *credentials) error {
+				return verifyBasicAuth(ctx, client, host, creds)
+			})
+		}
+		if creds == nil {
+			log.Debug().Str("host", host).Msg("no credentials, trying anonymous access")
+			return &anonymousAuthorizer{host: host, credentialsHost: host, client: client}, nil
+		}
+		if creds.password == "" && creds.identityToken != "" {
+			return nil, fmt.Errorf("%s uses Basic auth but only an identity token is configured; identity tokens can't be used with Basic auth", host)
+		}
+		return &basicAuthorizer{credentials: *creds}, nil
+	case "bearer":
+		return oauthAuthorizerFromChallenge(host, challenge, creds, credsHost, client), nil
+	}
+
+	return nil, fmt.Errorf("unsupported authentication scheme '%s' of %s", scheme, host)
 }
 
-func oauthAuthorizerFromChallenge(authenticate string, creds credentials) *oAuthAuthorizer {
-	realm, service, _ := extractOAuthSettings(authenticate)
-	return &oAuthAuthorizer{
-		authEndpoint:   realm,
-		service:        service,
-		credentials:    creds,
-		repoPullTokens: make(map[string]*token),
+// used for registries that don't require any authentication. Some
+// registries answer /v2/ anonymously but challenge the requests for
+// repositories; the first challenge switches to its authorizer.
+type anonymousAuthorizer struct {
+	host string
+	// nil if there are none
+	credentials     *credentials
+	credentialsHost string
+	client          *http.Client
+
+	// the authorizer of the challenge once a request was challenged
+	challenged authorizer
+	mutex      sync.Mutex
+}
+
+func (a *anonymousAuthorizer) authorize(req *http.Request, scopes ...string) error {
+	if auth := a.delegate(); auth != nil {
+		return auth.authorize(req, scopes...)
 	}
+	return nil
 }
 
-func (o *oAuthAuthorizer) authorizeRequest(req *http.Request) error {
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+func (a *anonymousAuthorizer) reauthorize(req *http.Request, challenge string) error {
+	a.mutex.Lock()
+	if a.challenged == nil && challenge != "" {
+		auth, err := authorizerFromChallenge(req.Context(), a.host, challenge, a.credentials, a.credentialsHost, a.client)
+		if err != nil {
+			a.mutex.Unlock()
+			return err
+		}
+		// basic auth without credentials stays anonymous
+		if _, anonymous := auth.(*anonymousAuthorizer); !anonymous {
+			log.Debug().Str("host", a.host).Msg("request was challenged, switching from anonymous access")
+			a.challenged = auth
+		}
 	}
+	auth := a.challenged
+	a.mutex.Unlock()
 
-	// the request does not need authorization
-	if resp.StatusCode == 200 {
+	if auth == nil {
 		return nil
 	}
+	return auth.reauthorize(req, challenge)
+}
 
-	resp.Body.Close()
+// Returns the authorizer of the challenge or nil if no
+// request was challenged yet
+func (a *anonymousAuthorizer) delegate() authorizer {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.challenged
+}
 
-	wwwAuth := resp.Header.Get("Www-authenticate")
+// used for registries that use HTTP basic authentication
+type basicAuthorizer struct {
+	credentials credentials
+}
 
-	if resp.StatusCode != 401 || wwwAuth == "" {
-		return fmt.Errorf("expected authentication request but got response status: %s", resp.Status)
+func (b *basicAuthorizer) authorize(req *http.Request, _ ...string) error {
+	req.SetBasicAuth(b.credentials.username, b.credentials.password)
+	return nil
+}
+
+func (b *basicAuthorizer) reauthorize(req *http.Request, _ string) error {
+	return b.authorize(req)
+}
+
+func verifyBasicAuth(ctx context.Context, client *http.Client, host string, creds *credentials) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", buildUrl(host, "v2/"), nil)
+	if err != nil {
+		return err
 	}
+	request.SetBasicAuth(creds.username, creds.password)
 
-	realm, service, scope := extractOAuthSettings(wwwAuth)
-	token, err := optainToken(realm, service, scope, &o.credentials)
+	resp, err := client.Do(request)
 	if err != nil {
 		return err
 	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return errors.New("invalid username or password")
+	}
+	return nil
+}
+
+// Requests bearer tokens from the token service. Without credentials
+// anonymous tokens are requested and the user is asked for credentials
+// once they don't suffice (see CredentialPrompt).
+type oAuthAuthorizer struct {
+	host         string
+	authEndpoint string
+	service      string
+	// nil when tokens are requested anonymously
+	credentials *credentials
+	// the name the credentials are stored under which is an alias
+	// of the host if they were found under it (e.g. docker.io)
+	credentialsHost string
+	client          *http.Client
+	// tokens by the scopes they were issued for (see scopeKey)
+	scopedTokens map[string]*token
+	// handed out by the token service for the credentials
+	refreshToken string
+	mutex        sync.Mutex
+}
+
+func oauthAuthorizerFromChallenge(host, authenticate string, creds *credentials, credsHost string, client *http.Client) *oAuthAuthorizer {
+	realm, service, _ := extractOAuthSettings(authenticate)
+	return &oAuthAuthorizer{
+		host:            host,
+		authEndpoint:    realm,
+		service:         service,
+		credentials:     creds,
+		credentialsHost: credsHost,
+		client:          client,
+		scopedTokens:    make(map[string]*token),
+	}
+}
+
+func (o *oAuthAuthorizer) authorize(req *http.Request, scopes ...string) error {
+	scopes = normalizeScopes(scopes)
+
+	token := o.cachedToken(scopes)
+	if token == nil {
+		var err error
+		token, err = o.tokenFor(req.Context(), o.authEndpoint, o.service, scopes, false)
+		if err != nil {
+			return err
+		}
+	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.token))
+	return nil
+}
+
+// Asks for a fresh token covering the scopes of the rejected
+// token as well as the scopes the challenge asks for
+func (o *oAuthAuthorizer) reauthorize(req *http.Request, challenge string) error {
+	realm, service, scopes := extractOAuthSettings(challenge)
+	if realm == "" {
+		realm, service = o.authEndpoint, o.service
+	}
+
+	rejected := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	o.mutex.Lock()
+	for key, token := range o.scopedTokens {
+		if token.token == rejected {
+			scopes = append(scopes, strings.Fields(key)...)
+			delete(o.scopedTokens, key)
+		}
+	}
+	o.mutex.Unlock()
+
+	// the anonymous token did not suffice
+	o.promptCredentials(req.Context())
+
+	token, err := o.tokenFor(req.Context(), realm, service, normalizeScopes(scopes), true)
+	if err != nil {
+		return err
+	}
 
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.token))
 	return nil
 }
 
-func (o *oAuthAuthorizer) authorizeRepoPull(req *http.Request, repo string) error {
-	repo = strings.TrimPrefix(repo, "/")
-	repo = strings.TrimSuffix(repo, "/")
+// Returns a valid token of this run which covers the scopes
+func (o *oAuthAuthorizer) cachedToken(scopes []string) *token {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	key := scopeKey(scopes)
+	if token, found := o.scopedTokens[key]; found && time.Now().Before(token.validUntil) {
+		return token
+	}
+
+	for grantedKey, token := range o.scopedTokens {
+		if time.Now().Before(token.validUntil) && scopesCover(strings.Fields(grantedKey), scopes) {
+			return token
+		}
+	}
+
+	return nil
+}
 
+// Returns the persisted token for the scopes or optains a new
+// one. With fresh the persisted tokens are not considered.
+func (o *oAuthAuthorizer) tokenFor(ctx context.Context, realm, service string, scopes []string, fresh bool) (*token, error) {
 	o.mutex.Lock()
-	token, found := o.repoPullTokens[repo]
+	creds := o.credentials
+	// once the token service handed out a refresh token
+	// the password is not sent anymore
+	sentCreds := creds
+	if o.refreshToken != "" {
+		sentCreds = &credentials{username: creds.username, identityToken: o.refreshToken}
+	}
 	o.mutex.Unlock()
 
-	if found {
-		if time.Now().Before(token.validUntil) {
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.token))
-			return nil
+	key := tokenKey(realm, service, scopeKey(scopes), creds)
+	if !fresh {
+		if token, found := tokens.get(key); found {
+			o.remember(scopes, token)
+			return token, nil
 		}
 	}
 
-	scope := "repository:" + repo + ":pull"
-	token, err := optainToken(o.authEndpoint, o.service, scope, &o.credentials)
+	token, err := optainToken(ctx, o.client, realm, service, scopes, sentCreds)
+	if err != nil && creds == nil && o.promptCredentials(ctx) {
+		return o.tokenFor(ctx, realm, service, scopes, fresh)
+	}
 	if err != nil {
+		return nil, err
+	}
+
+	if token.refreshToken != "" && creds != nil {
+		o.mutex.Lock()
+		o.refreshToken = token.refreshToken
+		credsHost := o.credentialsHost
+		o.mutex.Unlock()
+		setIdentityToken(credsHost, token.refreshToken)
+	}
+
+	o.remember(scopes, token)
+	tokens.put(key, token)
+	return token, nil
+}
+
+// Asks the user for credentials if there are none yet. Returns
+// true if the authorizer uses credentials from now on.
+func (o *oAuthAuthorizer) promptCredentials(ctx context.Context) bool {
+	o.mutex.Lock()
+	hasCredentials := o.credentials != nil
+	o.mutex.Unlock()
+	if hasCredentials {
+		return false
+	}
+
+	creds := promptCredentials(o.host, func(creds *credentials) error {
+		_, err := optainToken(ctx, o.client, o.authEndpoint, o.service, nil, creds)
 		return err
+	})
+	if creds == nil {
+		return false
 	}
 
 	o.mutex.Lock()
-	o.repoPullTokens[repo] = token
+	defer o.mutex.Unlock()
+
+	o.credentials = creds
+	// the prompted credentials are stored under the host
+	o.credentialsHost = o.host
+	// tokens of the anonymous user are of no use anymore
+	o.scopedTokens = make(map[string]*token)
+	return true
+}
+
+// Requests a token with the credentials to find out whether the
+// token service accepts them
+func (o *oAuthAuthorizer) verifyCredentials(ctx context.Context) error {
+	o.mutex.Lock()
+	creds, credsHost := o.credentials, o.credentialsHost
 	o.mutex.Unlock()
+	if creds == nil {
+		return fmt.Errorf("no credentials for %s", o.host)
+	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.token))
+	token, err := optainToken(ctx, o.client, o.authEndpoint, o.service, nil, creds)
+	if err != nil {
+		return err
+	}
+	if token.refreshToken != "" {
+		setIdentityToken(credsHost, token.refreshToken)
+	}
 	return nil
 }
 
-func optainToken(realm, service, scope string, creds *credentials) (*token, error) {
+func (o *oAuthAuthorizer) remember(scopes []string, token *token) {
+	o.mutex.Lock()
+	o.scopedTokens[scopeKey(scopes)] = token
+	o.mutex.Unlock()
+}
+
+// Optains a token for the scope. Identity tokens and passwords are
+// exchanged via the OAuth2 POST flow. Token services which don't
+// support it and anonymous requests use the legacy GET flow.
+func optainToken(ctx context.Context, client *http.Client, realm, service string, scopes []string, creds *credentials) (*token, error) {
 	// https://stackoverflow.com/questions/56193110/how-can-i-use-docker-registry-http-api-v2-to-obtain-a-list-of-all-repositories-i/68654659#68654659
 	// https://docs.docker.com/registry/spec/auth/token/
+	// https://docs.docker.com/registry/spec/auth/oauth/
+
+	if creds == nil {
+		return getToken(ctx, client, realm, service, scopes, nil)
+	}
+
+	values := make(url.Values)
+	values.Add("service", service)
+	values.Add("client_id", "ocapi")
+	// the OAuth2 flow expects the scopes space separated
+	if len(scopes) != 0 {
+		values.Add("scope", strings.Join(scopes, " "))
+	}
+
+	// identity tokens can only be used with the OAuth2 flow
+	if creds.identityToken != "" {
+		values.Add("grant_type", "refresh_token")
+		values.Add("refresh_token", creds.identityToken)
 
+		resp, err := postToken(ctx, client, realm, values)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			return nil, errors.New("could not authenticate with identity token")
+		}
+		return parseTokenResponse(resp)
+	}
+
+	values.Add("grant_type", "password")
+	values.Add("username", creds.username)
+	values.Add("password", creds.password)
+	// asks for a refresh token so that the password is only sent once
+	values.Add("access_type", "offline")
+
+	resp, err := postToken(ctx, client, realm, values)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		return parseTokenResponse(resp)
+	// the token service does not implement the OAuth2 flow
+	case 404, 405:
+		log.Debug().Str("realm", realm).Msg("falling back to GET token flow")
+		return getToken(ctx, client, realm, service, scopes, creds)
+	}
+
+	return nil, errors.New("could not authenticate with password")
+}
+
+func postToken(ctx context.Context, client *http.Client, realm string, values url.Values) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "POST", realm, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return client.Do(request)
+}
+
+// the legacy flow with the credentials in the URL
+func getToken(ctx context.Context, client *http.Client, realm, service string, scopes []string, creds *credentials) (*token, error) {
 	authUrl, err := url.Parse(realm)
 	if err != nil {
 		return nil, err
@@ -124,28 +446,45 @@ func optainToken(realm, service, scope string, creds *credentials) (*token, erro
 
 	values := make(url.Values)
 	values.Add("service", service)
-	values.Add("grant_type", "password")
 	values.Add("client_id", "dockerengine")
-	values.Add("scope", scope)
-	values.Add("username", creds.username)
-	values.Add("password", creds.password)
+	for _, scope := range scopes {
+		values.Add("scope", scope)
+	}
 
-	authUrl.RawQuery = values.Encode()
+	// without credentials an anonymous token is requested
+	if creds != nil {
+		values.Add("grant_type", "password")
+		values.Add("username", creds.username)
+		values.Add("password", creds.password)
 
-	// to access private docker-hub repos this is required
-	authUrl.User = url.UserPassword(creds.username, creds.password)
+		// to access private docker-hub repos this is required
+		authUrl.User = url.UserPassword(creds.username, creds.password)
+	}
+
+	authUrl.RawQuery = values.Encode()
 
-	resp, err := http.Get(authUrl.String())
+	request, err := http.NewRequestWithContext(ctx, "GET", authUrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(request)
 
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != 200 && creds == nil {
+		return nil, errors.New("could not optain anonymous token")
+	}
 	if resp.StatusCode != 200 {
 		return nil, errors.New("could not authenticate with password")
 	}
 
+	return parseTokenResponse(resp)
+}
+
+func parseTokenResponse(resp *http.Response) (*token, error) {
 	content, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -157,6 +496,11 @@ func optainToken(realm, service, scope string, creds *credentials) (*token, erro
 		return nil, errors.New("could not deserialize token response")
 	}
 
+	// the OAuth2 flow returns access_token instead of token
+	if tResp.Token == "" {
+		tResp.Token = tResp.AccessToken
+	}
+
 	tokenGeneratedAt := time.Now().Add(-time.Second)
 	if tResp.Issued != "" {
 		tokenGeneratedAt, err = time.Parse(time.RFC3339, tResp.Issued)
@@ -164,41 +508,133 @@ func optainToken(realm, service, scope string, creds *credentials) (*token, erro
 			return nil, errors.New("got bad token 'issued at' timestamp")
 		}
 	}
+	// the spec defines 60 seconds if the server does not tell
+	if tResp.Validity == 0 {
+		tResp.Validity = 60
+	}
 	validFor := time.Second * time.Duration(tResp.Validity)
 	validUntil := tokenGeneratedAt.Add(validFor).Add(-time.Second)
 	return &token{
-		token:      tResp.Token,
-		scope:      tResp.Scope,
-		validUntil: validUntil,
+		token:        tResp.Token,
+		scope:        tResp.Scope,
+		validUntil:   validUntil,
+		refreshToken: tResp.RefreshToken,
 	}, nil
 }
 
-func extractOAuthSettings(s string) (string, string, string) {
-	// Bearer realm="...",service="...",scope=""
+// Returns the realm, service and the scopes of a bearer challenge
+func extractOAuthSettings(s string) (string, string, []string) {
+	// Bearer realm="...",service="...",scope="a b"
+	_, params := parseChallenge(s)
+
+	return params["realm"], params["service"], strings.Fields(params["scope"])
+}
 
-	var realm, service, scope string
+// Parses the scheme and the parameters of a challenge
+// (Www-Authenticate header). Values may be tokens or
+// quoted strings which can contain commas and escaped quotes.
+// See: https://www.rfc-editor.org/rfc/rfc7235#section-2.1
+func parseChallenge(s string) (string, map[string]string) {
+	params := make(map[string]string)
 
-	_, rest, _ := strings.Cut(s, " ")
+	s = strings.TrimSpace(s)
+	scheme, rest, _ := strings.Cut(s, " ")
 
-	for _, item := range strings.Split(rest, ",") {
-		// item == x=".."
-		key, value, _ := strings.Cut(item, "=")
-		value = value[1 : len(value)-1]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, ", ")
 
-		switch key {
-		case "realm":
-			realm = value
-		case "service":
-			service = value
-		case "scope":
-			if scope == "" {
-				scope = value
-			} else {
-				scope += "," + value
+		var key string
+		var found bool
+		key, rest, found = strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			// skip the closing quote
+			if i < len(rest) {
+				i++
+			}
+			rest = rest[i:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end == -1 {
+				end = len(rest)
 			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			rest = rest[end:]
+		}
+
+		params[key] = value.String()
+	}
+
+	return scheme, params
+}
+
+// Merges the actions of the same resource and sorts the scopes so
+// that equal scope sets have the same key. Scopes look like
+// <type>:<name>:<action>[,<action>...]
+func normalizeScopes(scopes []string) []string {
+	granted := parseScopes(scopes)
+
+	normalized := make([]string, 0, len(granted))
+	for resource, actions := range granted {
+		list := make([]string, 0, len(actions))
+		for action := range actions {
+			list = append(list, action)
+		}
+		sort.Strings(list)
+		normalized = append(normalized, resource+":"+strings.Join(list, ","))
+	}
+	sort.Strings(normalized)
 
+	return normalized
+}
+
+func scopeKey(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// true if every action of wanted is part of granted
+func scopesCover(granted, wanted []string) bool {
+	grantedActions := parseScopes(granted)
+	for resource, actions := range parseScopes(wanted) {
+		for action := range actions {
+			if !grantedActions[resource][action] {
+				return false
+			}
 		}
 	}
+	return true
+}
 
-	return realm, service, scope
+// returns the actions by <type>:<name>
+func parseScopes(scopes []string) map[string]map[string]bool {
+	parsed := make(map[string]map[string]bool)
+	for _, scope := range scopes {
+		// names may contain colons, actions never do
+		i := strings.LastIndex(scope, ":")
+		if i == -1 {
+			continue
+		}
+		resource, actions := scope[:i], scope[i+1:]
+		if parsed[resource] == nil {
+			parsed[resource] = make(map[string]bool)
+		}
+		for _, action := range strings.Split(actions, ",") {
+			if action != "" {
+				parsed[resource][action] = true
+			}
+		}
+	}
+	return parsed
 }
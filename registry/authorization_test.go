@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		challenge string
+		scheme    string
+		params    map[string]string
+	}{
+		{
+			`Basic realm="registry"`,
+			"Basic",
+			map[string]string{"realm": "registry"},
+		},
+		{
+			`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:x:pull,push repository:y:pull",error="insufficient_scope"`,
+			"Bearer",
+			map[string]string{
+				"realm":   "https://auth.example.com/token",
+				"service": "registry.example.com",
+				"scope":   "repository:x:pull,push repository:y:pull",
+				"error":   "insufficient_scope",
+			},
+		},
+		{
+			`Bearer realm="say \"hi\"", Service=registry.example.com`,
+			"Bearer",
+			map[string]string{"realm": `say "hi"`, "service": "registry.example.com"},
+		},
+	}
+
+	for _, test := range tests {
+		scheme, params := parseChallenge(test.challenge)
+		if scheme != test.scheme || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: expected %s %v, got %s %v", test.challenge, test.scheme, test.params, scheme, params)
+		}
+	}
+}
+
+func TestExtractOAuthSettings(t *testing.T) {
+	challenge := `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:x:pull,push repository:y:pull",error="insufficient_scope"`
+
+	realm, service, scopes := extractOAuthSettings(challenge)
+	if realm != "https://auth.example.com/token" || service != "registry.example.com" {
+		t.Errorf("expected the realm and service of the challenge, got %s %s", realm, service)
+	}
+	if want := []string{"repository:x:pull,push", "repository:y:pull"}; !reflect.DeepEqual(scopes, want) {
+		t.Errorf("expected the scopes %v, got %v", want, scopes)
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		want   []string
+	}{
+		{nil, []string{}},
+		{[]string{"repository:x:push", "repository:x:pull"}, []string{"repository:x:pull,push"}},
+		{[]string{"repository:y:pull", "repository:x:pull,push", "repository:x:pull"}, []string{"repository:x:pull,push", "repository:y:pull"}},
+		{[]string{"registry:catalog:*"}, []string{"registry:catalog:*"}},
+		{[]string{"repository:localhost:5000/x:pull"}, []string{"repository:localhost:5000/x:pull"}},
+	}
+
+	for _, test := range tests {
+		if got := normalizeScopes(test.scopes); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: expected %v, got %v", test.scopes, test.want, got)
+		}
+	}
+}
+
+func TestScopesCover(t *testing.T) {
+	tests := []struct {
+		granted []string
+		wanted  []string
+		want    bool
+	}{
+		{[]string{"repository:x:pull,push"}, []string{"repository:x:pull"}, true},
+		{[]string{"repository:x:pull,push"}, []string{"repository:x:push", "repository:x:pull"}, true},
+		{[]string{"repository:x:pull", "repository:x:push"}, []string{"repository:x:pull,push"}, true},
+		{[]string{"repository:x:pull"}, []string{"repository:x:pull,push"}, false},
+		{[]string{"repository:x:pull,push"}, []string{"repository:y:pull"}, false},
+		{[]string{"repository:x:pull"}, nil, true},
+	}
+
+	for _, test := range tests {
+		if got := scopesCover(test.granted, test.wanted); got != test.want {
+			t.Errorf("%v covers %v: expected %v, got %v", test.granted, test.wanted, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Returns the descriptor (size, media type and digest) of the blob
+// without downloading it
+func (r *Registry) HeadBlob(ctx context.Context, imageName string, digest string) (*Descriptor, error) {
+	resp, err := r.blobRequest(ctx, "HEAD", imageName, digest)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	descriptor := &Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    digest,
+		Size:      resp.ContentLength,
+	}
+	if advertised := resp.Header.Get("Docker-Content-Digest"); advertised != "" {
+		descriptor.Digest = advertised
+	}
+
+	return descriptor, nil
+}
+
+// Returns a reader streaming the blob. Registries often redirect to
+// their storage backend which is followed (without the credentials
+// of the registry). The content is verified against the digest while
+// reading: the final Read returns a DigestMismatch if it does not match.
+func (r *Registry) GetBlob(ctx context.Context, imageName string, digest string) (io.ReadCloser, error) {
+	algorithm, _, _ := strings.Cut(digest, ":")
+	h := newDigestHash(algorithm)
+	if h == nil {
+		return nil, fmt.Errorf("unsupported digest '%s'", digest)
+	}
+
+	resp, err := r.blobRequest(ctx, "GET", imageName, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifyingReader{
+		body:     resp.Body,
+		hash:     h,
+		expected: digest,
+	}, nil
+}
+
+// Returns the content of a small blob (e.g. an image config).
+// Blobs are cached by their digest if the cache is enabled.
+func (r *Registry) getBlobContent(ctx context.Context, imageName string, digest string) ([]byte, error) {
+	if content, found := cache.getContent(digest); found {
+		if err := verifyDigest(digest, content); err == nil {
+			return content, nil
+		}
+		log.Debug().Str("digest", digest).Msg("ignoring bad cache entry")
+	}
+
+	blob, err := r.GetBlob(ctx, imageName, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	content, err := io.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.putContent(digest, content)
+	return content, nil
+}
+
+func (r *Registry) blobRequest(ctx context.Context, method, imageName, digest string) (*http.Response, error) {
+	imageName = strings.TrimSuffix(imageName, "/")
+	imageName = strings.TrimPrefix(imageName, "/")
+
+	blobUrl := buildUrl(r.Host, fmt.Sprintf("v2/%s/blobs/%s", imageName, digest))
+	log.Debug().Str("host", r.Host).Str("image", imageName).Str("digest", digest).Str("method", method).Msg("blob request")
+	request, err := http.NewRequestWithContext(ctx, method, blobUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.request(request, repositoryScope(imageName, "pull"))
+}
+
+// hashes everything read and compares it with
+// the expected digest once the body is consumed
+type verifyingReader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.body.Read(p)
+	v.hash.Write(p[:n])
+
+	if err == io.EOF {
+		algorithm, _, _ := strings.Cut(v.expected, ":")
+		actual := algorithm + ":" + hex.EncodeToString(v.hash.Sum(nil))
+		if actual != v.expected {
+			return n, DigestMismatch{Expected: v.expected, Actual: actual}
+		}
+	}
+
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.body.Close()
+}
@@ -0,0 +1,252 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// diskCache stores content addressed by digest (manifests, config blobs)
+// forever and listings (catalog, tags) for a short time.
+//
+// Layout:
+//
+//	<dir>/blobs/<algorithm>/<hex>   content by digest
+//	<dir>/lists/<sha256 of key>     json encoded listing
+type diskCache struct {
+	dir string
+	// how long listings are valid
+	ttl time.Duration
+}
+
+type cachedList struct {
+	Fetched time.Time `json:"fetched"`
+	Entries []string  `json:"entries"`
+}
+
+// nil if caching is disabled. All methods can be called on nil.
+var cache *diskCache
+
+// Returns $XDG_CACHE_HOME/ocapi (or the platform equivalent). It is
+// an error if neither $XDG_CACHE_HOME nor the home directory is set.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("no cache directory: %w", err)
+	}
+	return filepath.Join(dir, "ocapi"), nil
+}
+
+// Enables the on disk cache. Listings are reused for ttl.
+func EnableCache(dir string, ttl time.Duration) {
+	cache = &diskCache{
+		dir: expandUser(dir),
+		ttl: ttl,
+	}
+}
+
+func (c *diskCache) contentPath(digest string) (string, bool) {
+	algorithm, encoded, found := strings.Cut(digest, ":")
+	if !found || newDigestHash(algorithm) == nil || strings.ContainsAny(encoded, "/\\.") {
+		return "", false
+	}
+	return filepath.Join(c.dir, "blobs", algorithm, encoded), true
+}
+
+// Returns the content for the digest if it is cached. The caller
+// is responsible for verifying the content.
+func (c *diskCache) getContent(digest string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	path, ok := c.contentPath(digest)
+	if !ok {
+		return nil, false
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	// the modification time tells prune which entries are still used
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	log.Debug().Str("digest", digest).Msg("cache hit")
+	return content, true
+}
+
+func (c *diskCache) putContent(digest string, content []byte) {
+	if c == nil {
+		return
+	}
+	path, ok := c.contentPath(digest)
+	if !ok {
+		return
+	}
+
+	c.write(path, content)
+}
+
+func (c *diskCache) listPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, "lists", hex.EncodeToString(sum[:]))
+}
+
+// Returns the listing if it was cached less than ttl ago
+func (c *diskCache) getList(key string) ([]string, bool) {
+	if c == nil || c.ttl <= 0 {
+		return nil, false
+	}
+
+	content, err := os.ReadFile(c.listPath(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var list cachedList
+	if err := json.Unmarshal(content, &list); err != nil {
+		return nil, false
+	}
+	if time.Since(list.Fetched) > c.ttl {
+		return nil, false
+	}
+
+	log.Debug().Str("key", key).Msg("cache hit")
+	return list.Entries, true
+}
+
+func (c *diskCache) putList(key string, entries []string) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+
+	content, err := json.Marshal(cachedList{Fetched: time.Now(), Entries: entries})
+	if err != nil {
+		return
+	}
+
+	c.write(c.listPath(key), content)
+}
+
+// listings with more entries are not cached so that walking
+// huge catalogs does not hold them in memory
+const maxCachedListEntries = 10000
+
+// collects the pages of a walk to cache the listing once complete
+type listRecorder struct {
+	entries []string
+	// false if caching is disabled or the listing is too large
+	active bool
+}
+
+func (c *diskCache) recordList() *listRecorder {
+	return &listRecorder{active: c != nil && c.ttl > 0}
+}
+
+func (l *listRecorder) add(page []string) {
+	if !l.active {
+		return
+	}
+	if len(l.entries)+len(page) > maxCachedListEntries {
+		l.active = false
+		l.entries = nil
+		return
+	}
+	l.entries = append(l.entries, page...)
+}
+
+// caches the recorded listing unless it got too large
+func (c *diskCache) putRecordedList(key string, l *listRecorder) {
+	if l.active {
+		c.putList(key, l.entries)
+	}
+}
+
+// Failing to cache is not an error, it is only logged.
+func (c *diskCache) write(path string, content []byte) {
+	if err := writeFileAtomic(path, content); err != nil {
+		log.Debug().Str("path", path).Err(err).Msg("could not write cache entry")
+	}
+}
+
+// writes atomically so that parallel runs never see partial files.
+// The file is only readable by the user.
+func writeFileAtomic(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Removes listings older than ttl and content that has not
+// been used for olderThan. Returns the number of removed files.
+func PruneCache(dir string, ttl, olderThan time.Duration) (int, error) {
+	dir = expandUser(dir)
+	removed := 0
+
+	prune := func(subdir string, maxAge time.Duration) error {
+		root := filepath.Join(dir, subdir)
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if time.Since(info.ModTime()) <= maxAge {
+				return nil
+			}
+
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+			return nil
+		})
+
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if err := prune("lists", ttl); err != nil {
+		return removed, err
+	}
+	if err := prune("blobs", olderThan); err != nil {
+		return removed, err
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,35 @@
+package registry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListRecorder(t *testing.T) {
+	c := &diskCache{dir: t.TempDir(), ttl: time.Minute}
+
+	small := c.recordList()
+	small.add([]string{"a", "b"})
+	small.add([]string{"c"})
+	c.putRecordedList("small", small)
+	if entries, found := c.getList("small"); !found || len(entries) != 3 {
+		t.Fatalf("expected the small listing to be cached, got %v", entries)
+	}
+
+	large := c.recordList()
+	page := make([]string, maxCachedListEntries/2+1)
+	large.add(page)
+	large.add(page)
+	if large.entries != nil {
+		t.Fatal("expected the entries of a large listing to be dropped")
+	}
+	c.putRecordedList("large", large)
+	if _, found := c.getList("large"); found {
+		t.Fatal("expected the large listing not to be cached")
+	}
+
+	var disabled *diskCache
+	if disabled.recordList().active {
+		t.Fatal("expected nothing to be recorded without a cache")
+	}
+}
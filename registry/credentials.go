@@ -1,45 +1,360 @@
 package registry
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
 )
 
 type credentials struct {
 	username string
 	password string
+	// a refresh token (docker's identitytoken) used instead of the password
+	identityToken string
 }
 
 var credentialLookupTable map[string]credentials = make(map[string]credentials)
 
+// the credential helper (docker-credential-<name>) to use per host
+var credentialHelperByHost map[string]string = make(map[string]string)
+
+// the credential helper to use for all other hosts
+var defaultCredentialHelper string
+
+// hosts the credential helper has no credentials for so that
+// the helper is not run again for every lookup
+var credentialHelperMisses map[string]bool = make(map[string]bool)
+
+// docker stores the credentials of Docker Hub under its v1 index URL
+const dockerHubServerURL = "https://index.docker.io/v1/"
+
+// guards the tables above as helpers are queried lazily
+var credentialMutex sync.Mutex
+
+// Asks the user for the credentials of a host which has none
+// configured. previous is the reason the last attempt was rejected.
+// If save is true the credentials are stored in the auth file once
+// they are verified. nil means there is no one to ask.
+var CredentialPrompt func(host string, previous error) (username, password string, save bool, err error)
+
+// how often the user is asked if the credentials are rejected
+const promptAttempts = 3
+
+// ocapi's own auth file (see LoadCredentials)
+var ocapiAuthFile string
+
+// hosts the user was asked for; guarded by promptMutex
+// which makes sure that only one prompt is shown at a time
+var promptedHosts map[string]bool = make(map[string]bool)
+var promptMutex sync.Mutex
+
+// Returns the credentials for the host or nil if there are none.
+// Credential helpers are only asked when a host is looked up.
+func lookupCredentials(host string) *credentials {
+	if creds := credentialsFromEnv(host); creds != nil {
+		return creds
+	}
+
+	credentialMutex.Lock()
+	defer credentialMutex.Unlock()
+
+	creds, found := credentialLookupTable[host]
+	if found {
+		return &creds
+	}
+
+	helper, found := credentialHelperByHost[host]
+	if !found {
+		helper = defaultCredentialHelper
+	}
+	if helper == "" || credentialHelperMisses[host] {
+		return nil
+	}
+
+	for _, serverURL := range helperServerURLs(host) {
+		helperCreds, err := credentialsFromHelper(helper, serverURL)
+		if err != nil {
+			log.Debug().Str("host", serverURL).Str("helper", helper).Err(err).Msg("credential helper failed")
+			continue
+		}
+		if helperCreds != nil {
+			credentialLookupTable[host] = *helperCreds
+			return helperCreds
+		}
+	}
+
+	credentialHelperMisses[host] = true
+	return nil
+}
+
+// Returns the names a credential helper may know the host by
+func helperServerURLs(host string) []string {
+	if resolveAlias(host) == dockerHubHost {
+		return []string{host, dockerHubServerURL}
+	}
+	return []string{host}
+}
+
+// Asks the user for the credentials of the host (see CredentialPrompt)
+// until verify accepts them. The user is only asked once per host;
+// returns nil if the user was not asked or gave no credentials.
+func promptCredentials(host string, verify func(*credentials) error) *credentials {
+	promptMutex.Lock()
+	defer promptMutex.Unlock()
+
+	// another request might have asked in the meantime
+	if creds := lookupCredentials(host); creds != nil {
+		return creds
+	}
+	if CredentialPrompt == nil || promptedHosts[host] {
+		return nil
+	}
+	promptedHosts[host] = true
+
+	var previous error
+	for attempt := 0; attempt < promptAttempts; attempt++ {
+		username, password, save, err := CredentialPrompt(host, previous)
+		if err != nil || username == "" {
+			log.Debug().Str("host", host).Err(err).Msg("no credentials given")
+			return nil
+		}
+
+		creds := &credentials{username: username, password: password}
+		if previous = verify(creds); previous != nil {
+			continue
+		}
+
+		SetCredentials(host, username, password)
+		if save && ocapiAuthFile != "" {
+			if err := SaveCredentials(ocapiAuthFile, host); err != nil {
+				log.Debug().Str("host", host).Err(err).Msg("could not save credentials")
+			}
+		}
+		return creds
+	}
+
+	return nil
+}
+
+// Runs docker-credential-<helper> get for the host. Returns nil
+// if the helper does not have credentials for the host.
+// See: https://github.com/docker/docker-credential-helpers
+func credentialsFromHelper(helper, host string) (*credentials, error) {
+	type helperResponse struct {
+		ServerURL string `json:"ServerURL"`
+		Username  string `json:"Username"`
+		Secret    string `json:"Secret"`
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(host)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// the helper reports missing credentials on stdout
+		if strings.Contains(stdout.String(), "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stdout.String()+stderr.String()))
+	}
+
+	var resp helperResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, errors.New("credential helper returned an unknown format")
+	}
+
+	// identity tokens are returned with this magic username
+	if resp.Username == "<token>" {
+		return &credentials{identityToken: resp.Secret}, nil
+	}
+
+	return &credentials{
+		username: resp.Username,
+		password: resp.Secret,
+	}, nil
+}
+
+// Returns the credentials given via OCAPI_<HOST>_USERNAME and
+// OCAPI_<HOST>_PASSWORD where <HOST> is the host in upper case with
+// everything but letters and digits replaced by an underscore
+// e.g. registry.example.com:5000 -> REGISTRY_EXAMPLE_COM_5000.
+func credentialsFromEnv(host string) *credentials {
+	prefix := "OCAPI_" + envName(host)
+
+	username := os.Getenv(prefix + "_USERNAME")
+	password := os.Getenv(prefix + "_PASSWORD")
+	if username == "" || password == "" {
+		return nil
+	}
+
+	return &credentials{
+		username: username,
+		password: password,
+	}
+}
+
+func envName(host string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, host)
+}
+
+// Stores the credentials known for the host in the auth file (docker
+// config format) which is created if missing. Other entries are kept.
+// If the token service handed out a refresh token it is stored
+// instead of the password.
+func SaveCredentials(path, host string) error {
+	creds := lookupCredentials(host)
+	if creds == nil {
+		return fmt.Errorf("no credentials for %s", host)
+	}
+
+	path = filepath.Clean(path)
+	path = expandUser(path)
+
+	var df dockerConfig
+	content, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("could not read %s. Reason: %v", path, err)
+	default:
+		if err := json.Unmarshal(content, &df); err != nil {
+			return fmt.Errorf("%s seems to have a unknown format", path)
+		}
+	}
+
+	if df.Auth == nil {
+		df.Auth = make(map[string]dockerAuthEntry)
+	}
+	if creds.identityToken != "" {
+		df.Auth[host] = dockerAuthEntry{
+			Username:      creds.username,
+			IdentityToken: creds.identityToken,
+		}
+	} else {
+		df.Auth[host] = dockerAuthEntry{
+			Auth: base64.StdEncoding.EncodeToString([]byte(creds.username + ":" + creds.password)),
+		}
+	}
+
+	content, err = json.MarshalIndent(df, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// the credentials must only be readable by the user
+	return writeFileAtomic(path, content)
+}
+
+// Makes the credentials available for the host for the current process
+func SetCredentials(host, username, password string) {
+	credentialMutex.Lock()
+	defer credentialMutex.Unlock()
+
+	credentialLookupTable[host] = credentials{
+		username: username,
+		password: password,
+	}
+}
+
+// Remembers the refresh token the token service handed out for the
+// credentials of the host so that SaveCredentials can store it
+func setIdentityToken(host, identityToken string) {
+	credentialMutex.Lock()
+	defer credentialMutex.Unlock()
+
+	creds, found := credentialLookupTable[host]
+	if !found {
+		return
+	}
+	creds.identityToken = identityToken
+	credentialLookupTable[host] = creds
+}
+
+// Replaces a leading ~/ by the home directory. Without a home directory
+// (e.g. $HOME unset in CI) the path is returned as is and such files
+// are treated as missing.
 func expandUser(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
 	home, err := os.UserHomeDir()
 	if err != nil {
-		panic(err)
+		log.Debug().Str("path", path).Err(err).Msg("could not expand path")
+		return path
 	}
 
-	if strings.HasPrefix(path, "~/") {
-		path = filepath.Join(home, path[2:])
-	}
+	return filepath.Join(home, path[2:])
+}
 
-	return path
+// the format shared by docker's config.json, podman's auth.json and ocapi's auth.json
+type dockerConfig struct {
+	Auth        map[string]dockerAuthEntry `json:"auths"`
+	CredsStore  string                     `json:"credsStore,omitempty"`
+	CredHelpers map[string]string          `json:"credHelpers,omitempty"`
 }
 
-func LoadCredentialsFromDockerConfig(path string) error {
-	type dockerConfig struct {
-		Auth map[string]struct {
-			Username string `json:"username"`
-			Password string `json:"password"`
-			Auth     string `json:"auth"`
-		} `json:"auths"`
+type dockerAuthEntry struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// Loads the credentials from all known sources. Later sources
+// take precedence over earlier ones:
+//   - the docker config
+//   - podman's ${XDG_RUNTIME_DIR}/containers/auth.json
+//   - the file in ${REGISTRY_AUTH_FILE}
+//   - ocapi's own auth file (see ocapi login)
+//
+// Credentials in the environment (see credentialsFromEnv)
+// take precedence over all of them.
+func LoadCredentials(dockerConfigPath, authFile string) error {
+	sources := []string{dockerConfigPath}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		sources = append(sources, filepath.Join(runtimeDir, "containers", "auth.json"))
+	}
+	if registryAuthFile := os.Getenv("REGISTRY_AUTH_FILE"); registryAuthFile != "" {
+		sources = append(sources, registryAuthFile)
+	}
+	sources = append(sources, authFile)
+
+	// prompted credentials are saved there
+	ocapiAuthFile = authFile
+
+	for _, source := range sources {
+		if err := LoadCredentialsFromDockerConfig(source); err != nil {
+			return err
+		}
 	}
 
+	return nil
+}
+
+// Loads the credentials from a file in the docker config format.
+// A missing file is not an error as credentials are optional.
+func LoadCredentialsFromDockerConfig(path string) error {
 	path = filepath.Clean(path)
 	path = expandUser(path)
 	path, err := filepath.Abs(path)
@@ -48,21 +363,38 @@ func LoadCredentialsFromDockerConfig(path string) error {
 	}
 
 	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Debug().Str("path", path).Msg("no credentials file")
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("could not load docker config. Reason: %v", err)
 	}
 
 	var df dockerConfig
 	if err := json.Unmarshal(content, &df); err != nil {
-		return errors.New("docker config seems to have a unknown format")
+		return fmt.Errorf("%s seems to have a unknown format", path)
+	}
+
+	credentialMutex.Lock()
+	defer credentialMutex.Unlock()
+
+	if df.CredsStore != "" {
+		defaultCredentialHelper = df.CredsStore
+	}
+	for k, helper := range df.CredHelpers {
+		credentialHelperByHost[hostOfConfigEntry(k)] = helper
 	}
 
 	for k, v := range df.Auth {
-		host := k
+		host := hostOfConfigEntry(k)
 
-		// some entries look like https://some.host/endpoint
-		if reg, err := url.Parse(k); err == nil && reg.Host != "" {
-			host = reg.Host
+		if v.IdentityToken != "" {
+			credentialLookupTable[host] = credentials{
+				username:      v.Username,
+				identityToken: v.IdentityToken,
+			}
+			continue
 		}
 
 		if v.Username != "" && v.Password != "" {
@@ -88,9 +420,17 @@ func LoadCredentialsFromDockerConfig(path string) error {
 			continue
 		}
 
-		// TODO: use debug logging
-		fmt.Println("Unusable auth entry in docker config")
+		// entries are empty when the credentials are kept in a credsStore
+		log.Debug().Str("host", host).Msg("unusable auth entry in docker config")
 	}
 
 	return nil
 }
+
+// some entries look like https://some.host/endpoint
+func hostOfConfigEntry(entry string) string {
+	if reg, err := url.Parse(entry); err == nil && reg.Host != "" {
+		return reg.Host
+	}
+	return entry
+}
@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// A docker-credential-fake on PATH which knows the credentials
+// of Docker Hub (under its server URL) and of registry.example.com.
+// Every query is appended to the returned log file.
+func fakeCredentialHelper(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake credential helper is a shell script")
+	}
+
+	dir := t.TempDir()
+	queries := filepath.Join(dir, "queries")
+	script := `#!/bin/sh
+read server
+echo "$server" >> ` + queries + `
+case "$server" in
+https://index.docker.io/v1/)
+	echo '{"ServerURL":"https://index.docker.io/v1/","Username":"hub","Secret":"hub-secret"}';;
+registry.example.com)
+	echo '{"ServerURL":"registry.example.com","Username":"<token>","Secret":"identity"}';;
+*)
+	echo "credentials not found in native keychain"
+	exit 1;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	defaultCredentialHelper = "fake"
+	t.Cleanup(func() {
+		defaultCredentialHelper = ""
+		credentialLookupTable = make(map[string]credentials)
+		credentialHelperMisses = make(map[string]bool)
+	})
+
+	return queries
+}
+
+func helperQueries(t *testing.T, path string) []string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(content))
+}
+
+func TestLookupCredentialsFromHelper(t *testing.T) {
+	fakeCredentialHelper(t)
+
+	creds := lookupCredentials("registry.example.com")
+	if creds == nil || creds.identityToken != "identity" {
+		t.Fatalf("expected the identity token of registry.example.com, got %+v", creds)
+	}
+}
+
+func TestLookupCredentialsDockerHubServerURL(t *testing.T) {
+	queries := fakeCredentialHelper(t)
+
+	for _, host := range []string{"docker.io", "index.docker.io", dockerHubHost} {
+		creds := lookupCredentials(host)
+		if creds == nil || creds.username != "hub" || creds.password != "hub-secret" {
+			t.Fatalf("%s: expected the Docker Hub credentials, got %+v", host, creds)
+		}
+	}
+
+	got := helperQueries(t, queries)
+	if len(got) != 6 || got[1] != dockerHubServerURL {
+		t.Fatalf("expected every host to be tried before the server URL, got %v", got)
+	}
+}
+
+func TestLookupCredentialsCachesMisses(t *testing.T) {
+	queries := fakeCredentialHelper(t)
+
+	for i := 0; i < 3; i++ {
+		if creds := lookupCredentials("unknown.example.com"); creds != nil {
+			t.Fatalf("expected no credentials, got %+v", creds)
+		}
+	}
+
+	if got := helperQueries(t, queries); len(got) != 1 {
+		t.Fatalf("expected the helper to be run once, got %v", got)
+	}
+}
+
+func TestSaveCredentialsRestrictsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, []byte(`{"auths":{}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	SetCredentials("save.example.com", "user", "secret")
+	t.Cleanup(func() {
+		credentialLookupTable = make(map[string]credentials)
+	})
+
+	if err := SaveCredentials(path, "save.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Fatalf("expected mode 0600, got %o", mode)
+	}
+}
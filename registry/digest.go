@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+type DigestMismatch struct {
+	Expected string
+	Actual   string
+}
+
+func (e DigestMismatch) Error() string {
+	return fmt.Sprintf("digest mismatch: expected %s but content has %s", e.Expected, e.Actual)
+}
+
+// Returns true if the reference (the part after name: or name@)
+// is a digest rather than a tag.
+func IsDigest(reference string) bool {
+	algorithm, _, found := strings.Cut(reference, ":")
+	return found && newDigestHash(algorithm) != nil
+}
+
+func newDigestHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// Computes the digest of the content using the given algorithm
+func ComputeDigest(algorithm string, content []byte) (string, error) {
+	h := newDigestHash(algorithm)
+	if h == nil {
+		return "", fmt.Errorf("unsupported digest algorithm '%s'", algorithm)
+	}
+
+	h.Write(content)
+	return algorithm + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Returns an error if the content does not match the expected digest
+func verifyDigest(expected string, content []byte) error {
+	algorithm, _, _ := strings.Cut(expected, ":")
+	actual, err := ComputeDigest(algorithm, content)
+	if err != nil {
+		return err
+	}
+
+	if actual != expected {
+		return DigestMismatch{Expected: expected, Actual: actual}
+	}
+
+	return nil
+}
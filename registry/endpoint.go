@@ -0,0 +1,162 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// endpoint is a host serving the content of a registry. That is
+// the registry itself (or the host it is an alias of) or a mirror.
+type endpoint struct {
+	host   string
+	auth   authorizer
+	client *http.Client
+	// shared by all registries of the same host
+	scheduler *hostScheduler
+}
+
+// Connects to the host and picks the authentication method based
+// on the challenge of the registry: anonymous, basic or bearer
+// tokens (anonymous ones if there are no credentials). names are
+// the other names of the host to look up credentials for.
+func newEndpoint(ctx context.Context, host string, names ...string) (*endpoint, error) {
+	// refresh tokens are stored under the name the credentials were found under
+	creds, credsHost := lookupCredentials(host), host
+	for _, name := range names {
+		if creds != nil {
+			break
+		}
+		creds, credsHost = lookupCredentials(name), name
+	}
+	if creds == nil {
+		credsHost = host
+	}
+
+	client, err := clientFor(host)
+	if err != nil {
+		return nil, err
+	}
+
+	// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#determining-support
+	request, err := http.NewRequestWithContext(ctx, "GET", buildUrl(host, "v2/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	wwwAuth := resp.Header.Get("Www-authenticate")
+
+	switch {
+	case resp.StatusCode == 200:
+		wwwAuth = ""
+	case resp.StatusCode == 401 && wwwAuth != "":
+	default:
+		return nil, fmt.Errorf("expected auth challenge from registry, but got: %s", resp.Status)
+	}
+
+	auth, err := authorizerFromChallenge(ctx, host, wwwAuth, creds, credsHost, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &endpoint{
+		host:      host,
+		auth:      auth,
+		client:    client,
+		scheduler: schedulerFor(host),
+	}, nil
+}
+
+// Checks that the credentials are accepted. Requests of registries
+// allowing anonymous access succeed with any credentials so they
+// are verified explicitly.
+func (e *endpoint) verifyCredentials(ctx context.Context) error {
+	auth := e.auth
+	if anonymous, ok := auth.(*anonymousAuthorizer); ok && anonymous.delegate() != nil {
+		auth = anonymous.delegate()
+	}
+
+	switch auth := auth.(type) {
+	case *basicAuthorizer:
+		return verifyBasicAuth(ctx, e.client, e.host, &auth.credentials)
+	case *oAuthAuthorizer:
+		return auth.verifyCredentials(ctx)
+	}
+	return fmt.Errorf("%s does not ask for credentials, they can't be verified", e.host)
+}
+
+// authorizes the request for the scopes, makes it and performs some
+// common error checking and retry logic. Unsuccessful responses are
+// returned as *RegistryError.
+func (e *endpoint) request(request *http.Request, scopes []string) (*http.Response, error) {
+	request.Header.Set("Accept-Encoding", "*")
+
+	if err := e.auth.authorize(request, scopes...); err != nil {
+		return nil, err
+	}
+
+	reauthorized := false
+	for attempt := 0; ; attempt++ {
+		resp, err := e.do(request)
+
+		if err == nil && resp.StatusCode == 401 && !reauthorized {
+			resp.Body.Close()
+
+			// retry one more time with fresh token
+			if err := e.auth.reauthorize(request, resp.Header.Get("Www-Authenticate")); err != nil {
+				return nil, err
+			}
+			reauthorized = true
+			attempt--
+			continue
+		}
+
+		if err == nil && resp.StatusCode == 200 {
+			return resp, nil
+		}
+
+		// the request was aborted on purpose
+		if request.Context().Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, request.Context().Err()
+		}
+
+		if isIdempotent(request) && attempt < MaxRetries {
+			if wait, retry := retryDelay(resp, err, attempt); retry {
+				if resp != nil {
+					resp.Body.Close()
+				}
+				log.Debug().Str("host", e.host).Str("url", request.URL.String()).Dur("wait", wait).Msg("retrying request")
+				if err := sleep(request.Context(), wait); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		return nil, registryErrorFromResponse(resp)
+	}
+}
+
+// makes a single request limited by the scheduler of the host
+func (e *endpoint) do(request *http.Request) (*http.Response, error) {
+	if err := e.scheduler.acquire(request.Context()); err != nil {
+		return nil, err
+	}
+	defer e.scheduler.release()
+
+	log.Debug().Str("host", e.host).Str("url", request.URL.String()).Msg("request")
+	return e.client.Do(request)
+}
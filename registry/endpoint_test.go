@@ -0,0 +1,137 @@
+package registry_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sojamann/ocapi/registry"
+	"github.com/sojamann/ocapi/registry/registrytest"
+)
+
+func TestVerifyCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		option   registrytest.Option
+		password string
+		ok       bool
+	}{
+		{"anonymous registry", nil, "secret", false},
+		{"basic", registrytest.WithBasicAuth("user", "secret"), "secret", true},
+		{"basic wrong password", registrytest.WithBasicAuth("user", "secret"), "wrong", false},
+		{"bearer", registrytest.WithBearerAuth("user", "secret"), "secret", true},
+		{"bearer wrong password", registrytest.WithBearerAuth("user", "secret"), "wrong", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fake *registrytest.Registry
+			if test.option == nil {
+				fake = registrytest.New()
+			} else {
+				fake = registrytest.New(test.option)
+			}
+			defer fake.Close()
+
+			registry.SetCredentials(fake.Host(), "user", test.password)
+			r, err := registry.NewRegisty(context.Background(), fake.Host())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = r.VerifyCredentials(context.Background())
+			if test.ok && err != nil {
+				t.Fatalf("expected the credentials to be accepted, got %v", err)
+			}
+			if !test.ok && err == nil {
+				t.Fatal("expected the credentials to be rejected")
+			}
+		})
+	}
+}
+
+func TestChallengeAfterAnonymousPing(t *testing.T) {
+	tests := []struct {
+		name   string
+		option registrytest.Option
+	}{
+		{"basic", registrytest.WithBasicAuth("user", "secret")},
+		{"bearer", registrytest.WithBearerAuth("user", "secret")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := registrytest.New(test.option, registrytest.WithPublicPing())
+			defer fake.Close()
+			fake.PushOCIImage("lib/app", "1", nil, []byte("layer"))
+
+			registry.SetCredentials(fake.Host(), "user", "secret")
+			r, err := registry.NewRegisty(context.Background(), fake.Host())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tags, err := r.GetTags(context.Background(), "lib/app")
+			if err != nil {
+				t.Fatalf("expected the credentials to be used once challenged, got %v", err)
+			}
+			if len(tags) != 1 {
+				t.Fatalf("expected 1 tag, got %v", tags)
+			}
+			if err := r.VerifyCredentials(context.Background()); err != nil {
+				t.Fatalf("expected the credentials to be accepted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenOfAlias(t *testing.T) {
+	fake := registrytest.New(registrytest.WithBearerAuth("user", "secret"), registrytest.WithRefreshToken("refresh"))
+	defer fake.Close()
+	fake.PushOCIImage("lib/app", "1", nil, []byte("layer"))
+
+	// the credentials are only known under the alias
+	alias := "alias-of-" + fake.Host()
+	registry.SetAlias(alias, fake.Host())
+	registry.SetCredentials(alias, "user", "secret")
+
+	r, err := registry.NewRegisty(context.Background(), fake.Host())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetTags(context.Background(), "lib/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the password was exchanged for a refresh token which is saved instead
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	if err := registry.SaveCredentials(authFile, alias); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(authFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `"identitytoken"`) {
+		t.Fatalf("expected the refresh token to be saved for %s, got %s", alias, content)
+	}
+
+	// a saved identity token is accepted by the token endpoint
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	config := `{"auths": {"` + fake.Host() + `": {"username": "user", "identitytoken": "refresh"}}}`
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.LoadCredentialsFromDockerConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	r, err = registry.NewRegisty(context.Background(), fake.Host())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetTags(context.Background(), "lib/app"); err != nil {
+		t.Fatalf("expected the identity token to be accepted, got %v", err)
+	}
+}
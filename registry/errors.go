@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Error codes defined by the distribution spec
+// See: https://github.com/opencontainers/distribution-spec/blob/main/spec.md#error-codes
+const (
+	ErrCodeBlobUnknown         = "BLOB_UNKNOWN"
+	ErrCodeBlobUploadInvalid   = "BLOB_UPLOAD_INVALID"
+	ErrCodeBlobUploadUnknown   = "BLOB_UPLOAD_UNKNOWN"
+	ErrCodeDigestInvalid       = "DIGEST_INVALID"
+	ErrCodeManifestBlobUnknown = "MANIFEST_BLOB_UNKNOWN"
+	ErrCodeManifestInvalid     = "MANIFEST_INVALID"
+	ErrCodeManifestUnknown     = "MANIFEST_UNKNOWN"
+	ErrCodeNameInvalid         = "NAME_INVALID"
+	ErrCodeNameUnknown         = "NAME_UNKNOWN"
+	ErrCodeSizeInvalid         = "SIZE_INVALID"
+	ErrCodeUnauthorized        = "UNAUTHORIZED"
+	ErrCodeDenied              = "DENIED"
+	ErrCodeUnsupported         = "UNSUPPORTED"
+	ErrCodeTooManyRequests     = "TOOMANYREQUESTS"
+)
+
+// ErrorDetail is one entry of the errors list a registry responds with
+type ErrorDetail struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// RegistryError is returned for every unsuccessful response. Code,
+// Message and Detail are taken from the first error the registry
+// reported (if any) and Errors holds all of them.
+type RegistryError struct {
+	StatusCode int
+	Status     string
+	Code       string
+	Message    string
+	Detail     json.RawMessage
+	Errors     []ErrorDetail
+}
+
+func (e *RegistryError) Error() string {
+	msg := fmt.Sprintf("registry responded with %s", e.Status)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	// without a code the message is the plain text body
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if len(e.Detail) != 0 && string(e.Detail) != "null" {
+		msg += fmt.Sprintf(" (%s)", e.Detail)
+	}
+	return msg
+}
+
+// Makes the error usable with the sentinel errors
+// e.g. errors.Is(err, ErrResourceDoesNotExist)
+func (e *RegistryError) Is(target error) bool {
+	switch target {
+	case ErrResourceDoesNotExist:
+		return e.StatusCode == 404
+	case ErrImageDoesNotExist:
+		return e.Code == ErrCodeNameUnknown || e.Code == ErrCodeManifestUnknown
+	case ErrNotAllowedOrUnavailable:
+		return e.StatusCode == 401 || e.StatusCode == 403
+	}
+	return false
+}
+
+// Returns true if the registry reported the given code
+func (e *RegistryError) HasCode(code string) bool {
+	for _, detail := range e.Errors {
+		if detail.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds the error from the response and closes its body
+func registryErrorFromResponse(resp *http.Response) *RegistryError {
+	defer resp.Body.Close()
+
+	regErr := &RegistryError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+
+	// at most read 1MiB, error bodies are small
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil || len(content) == 0 {
+		return regErr
+	}
+
+	var body struct {
+		Errors []ErrorDetail `json:"errors"`
+	}
+	if err := json.Unmarshal(content, &body); err != nil || len(body.Errors) == 0 {
+		// some registries or proxies respond with plain text
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			regErr.Message = strings.TrimSpace(string(content))
+		}
+		return regErr
+	}
+
+	regErr.Errors = body.Errors
+	regErr.Code = body.Errors[0].Code
+	regErr.Message = body.Errors[0].Message
+	regErr.Detail = body.Errors[0].Detail
+
+	return regErr
+}
@@ -0,0 +1,36 @@
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegistryErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  RegistryError
+		want string
+	}{
+		{
+			RegistryError{Status: "502 Bad Gateway"},
+			"registry responded with 502 Bad Gateway",
+		},
+		{
+			RegistryError{Status: "502 Bad Gateway", Message: "upstream timed out"},
+			"registry responded with 502 Bad Gateway: upstream timed out",
+		},
+		{
+			RegistryError{Status: "404 Not Found", Code: ErrCodeManifestUnknown, Message: "manifest unknown"},
+			"registry responded with 404 Not Found: MANIFEST_UNKNOWN: manifest unknown",
+		},
+		{
+			RegistryError{Status: "403 Forbidden", Code: "DENIED", Detail: json.RawMessage(`{"reason":"quota"}`)},
+			`registry responded with 403 Forbidden: DENIED ({"reason":"quota"})`,
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("expected %q, got %q", test.want, got)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HostConfig holds the connection settings of one registry host
+type HostConfig struct {
+	// talk plain HTTP instead of HTTPS
+	Insecure bool `json:"insecure"`
+	// don't verify the certificate of the registry
+	SkipVerify bool `json:"skipVerify"`
+	// additional CA certificates (PEM) to trust
+	CAFiles []string `json:"caFiles"`
+	// client certificate and key (PEM) to authenticate with
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// parallel requests; 0 means DefaultConcurrency
+	Concurrency int `json:"concurrency"`
+	// 0 means DefaultRequestsPerSecond
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	// hosts serving pulls of this host, tried in order before the host itself
+	Mirrors []string `json:"mirrors"`
+}
+
+// Directories laid out like docker's certs.d (<dir>/<host>/*.crt for CAs
+// and <dir>/<host>/*.cert + *.key for client certificates).
+var CertsDirs = []string{"/etc/docker/certs.d", "~/.docker/certs.d"}
+
+var hostConfigs map[string]HostConfig = make(map[string]HostConfig)
+
+// the host serving Docker Hub
+const dockerHubHost = "registry-1.docker.io"
+
+// hosts that are known by another name e.g. docker.io
+// which is served by registry-1.docker.io
+var hostAliases map[string]string = map[string]string{
+	"docker.io":       dockerHubHost,
+	"index.docker.io": dockerHubHost,
+}
+
+// a map that stores the http client per host so that
+// connections are reused across registries of the same host
+var clientByHost sync.Map
+
+// Sets the connection settings for the host
+func ConfigureHost(host string, config HostConfig) {
+	hostConfigs[host] = config
+}
+
+// Marks the host as reachable via plain HTTP
+func SetInsecure(host string) {
+	config := hostConfigs[host]
+	config.Insecure = true
+	hostConfigs[host] = config
+}
+
+// Disables the certificate verification of the host
+func SetSkipVerify(host string) {
+	config := hostConfigs[host]
+	config.SkipVerify = true
+	hostConfigs[host] = config
+}
+
+// Makes the registry at host available as alias
+func SetAlias(alias, host string) {
+	hostAliases[alias] = host
+}
+
+// Loads the ocapi config which holds the per host connection settings
+// and host aliases. A missing file is not an error.
+func LoadConfig(path string) error {
+	type ocapiConfig struct {
+		Registries map[string]HostConfig `json:"registries"`
+		Aliases    map[string]string     `json:"aliases"`
+	}
+
+	path = filepath.Clean(path)
+	path = expandUser(path)
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not load config. Reason: %v", err)
+	}
+
+	var config ocapiConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return fmt.Errorf("config %s seems to have a unknown format: %v", path, err)
+	}
+
+	for host, hostConfig := range config.Registries {
+		ConfigureHost(host, hostConfig)
+	}
+	for alias, host := range config.Aliases {
+		SetAlias(alias, host)
+	}
+
+	return nil
+}
+
+// Returns the host serving the registry known as host
+func resolveAlias(host string) string {
+	if resolved, found := hostAliases[host]; found {
+		return resolved
+	}
+	return host
+}
+
+// Returns the aliases of the host
+func aliasesOf(host string) []string {
+	aliases := make([]string, 0)
+	for alias, resolved := range hostAliases {
+		if resolved == host {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// Returns the mirrors configured for the host or the host it is an alias of
+func mirrorsOf(host string) []string {
+	if mirrors := hostConfigs[host].Mirrors; len(mirrors) != 0 {
+		return mirrors
+	}
+	return hostConfigs[resolveAlias(host)].Mirrors
+}
+
+func lookupHostConfig(host string) HostConfig {
+	config := hostConfigs[host]
+	// like docker, local registries are expected to be plain HTTP
+	config.Insecure = config.Insecure || isLocalhost(host)
+	return config
+}
+
+func isLocalhost(host string) bool {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = host
+	}
+
+	if hostname == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+func schemeOf(host string) string {
+	if lookupHostConfig(host).Insecure {
+		return "http"
+	}
+	return "https"
+}
+
+// Returns the http client to use for the host. Clients
+// are shared by all registries of the same host.
+func clientFor(host string) (*http.Client, error) {
+	if client, found := clientByHost.Load(host); found {
+		return client.(*http.Client), nil
+	}
+
+	tlsConfig, err := tlsConfigFor(host, lookupHostConfig(host))
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	client, _ := clientByHost.LoadOrStore(host, &http.Client{Transport: transport})
+	return client.(*http.Client), nil
+}
+
+func tlsConfigFor(host string, config HostConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.SkipVerify,
+	}
+
+	caFiles := config.CAFiles
+	certFiles := make([][2]string, 0)
+	if config.CertFile != "" {
+		certFiles = append(certFiles, [2]string{config.CertFile, config.KeyFile})
+	}
+
+	for _, dir := range CertsDirs {
+		cas, certs, err := readCertsDir(filepath.Join(expandUser(dir), host))
+		if err != nil {
+			return nil, err
+		}
+		caFiles = append(caFiles, cas...)
+		certFiles = append(certFiles, certs...)
+	}
+
+	if len(caFiles) != 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, caFile := range caFiles {
+			pem, err := os.ReadFile(expandUser(caFile))
+			if err != nil {
+				return nil, fmt.Errorf("could not read CA of %s: %v", host, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	for _, pair := range certFiles {
+		cert, err := tls.LoadX509KeyPair(expandUser(pair[0]), expandUser(pair[1]))
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate of %s: %v", host, err)
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+
+	return tlsConfig, nil
+}
+
+// Reads the docker certs.d directory of a host returning
+// the CA files and the client certificate/key pairs.
+// See: https://docs.docker.com/engine/security/certificates/
+func readCertsDir(dir string) ([]string, [][2]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cas := make([]string, 0)
+	certs := make([][2]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+
+		switch {
+		case strings.HasSuffix(name, ".crt"):
+			cas = append(cas, path)
+		case strings.HasSuffix(name, ".cert"):
+			keyPath := strings.TrimSuffix(path, ".cert") + ".key"
+			if _, err := os.Stat(keyPath); err != nil {
+				return nil, nil, fmt.Errorf("missing key %s for client certificate %s", keyPath, path)
+			}
+			certs = append(certs, [2]string{path, keyPath})
+		}
+	}
+
+	return cas, certs, nil
+}
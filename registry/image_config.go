@@ -0,0 +1,125 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ImageConfig is the OCI image configuration (which docker's
+// schema2 config is compatible with).
+// See: https://github.com/opencontainers/image-spec/blob/main/config.md
+type ImageConfig struct {
+	Created      *time.Time      `json:"created,omitempty"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	OSVersion    string          `json:"os.version,omitempty"`
+	Variant      string          `json:"variant,omitempty"`
+	Config       ContainerConfig `json:"config"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []History       `json:"history,omitempty"`
+
+	// the config as returned by the registry; not part of the config itself
+	Raw json.RawMessage `json:"-"`
+}
+
+// ContainerConfig holds the defaults for containers of the image
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+type RootFS struct {
+	Type string `json:"type"`
+	// digests of the uncompressed layers with the base layer first
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type History struct {
+	Created    *time.Time `json:"created,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	Author     string     `json:"author,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	EmptyLayer bool       `json:"empty_layer,omitempty"`
+}
+
+// Returns the config of the image described by the manifest. For
+// schema2 and OCI manifests this is the config blob, schema1 manifests
+// carry it in the v1Compatibility of the top most layer.
+func (r *Registry) GetImageConfig(ctx context.Context, imageName string, manifest *Manifest) (*ImageConfig, error) {
+	var content []byte
+	switch {
+	case manifest.IsIndex():
+		return nil, errors.New("an index has no config, pick the manifest of a platform")
+	case manifest.IsSchema1():
+		// the top most layer comes first and holds the config of the image
+		if len(manifest.History) == 0 {
+			return nil, errors.New("schema1 manifest has no history")
+		}
+		content = []byte(manifest.History[0].V1Compatibility)
+	case manifest.Config != nil:
+		var err error
+		content, err = r.getBlobContent(ctx, imageName, manifest.Config.Digest)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("manifest has no config")
+	}
+
+	var config ImageConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("could not parse image config: %v", err)
+	}
+	config.Raw = content
+
+	// schema1 has one history entry per layer
+	if manifest.IsSchema1() {
+		config.History = schema1History(manifest)
+		if config.Architecture == "" {
+			config.Architecture = manifest.Architecture
+		}
+	}
+
+	return &config, nil
+}
+
+// builds the history (base layer first) from the v1Compatibility entries
+func schema1History(manifest *Manifest) []History {
+	type v1Compatibility struct {
+		Created         *time.Time `json:"created"`
+		Author          string     `json:"author"`
+		Comment         string     `json:"comment"`
+		ContainerConfig struct {
+			Cmd []string `json:"Cmd"`
+		} `json:"container_config"`
+		ThrowAway bool `json:"throwaway"`
+	}
+
+	history := make([]History, 0, len(manifest.History))
+	for i := len(manifest.History) - 1; i >= 0; i-- {
+		var entry v1Compatibility
+		if err := json.Unmarshal([]byte(manifest.History[i].V1Compatibility), &entry); err != nil {
+			continue
+		}
+		history = append(history, History{
+			Created:    entry.Created,
+			CreatedBy:  strings.Join(entry.ContainerConfig.Cmd, " "),
+			Author:     entry.Author,
+			Comment:    entry.Comment,
+			EmptyLayer: entry.ThrowAway,
+		})
+	}
+	return history
+}
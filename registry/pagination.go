@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The number of entries requested per page when listing the catalog
+// or tags. If 0 the registry decides on the page size.
+var PageSize = 100
+
+// adds the page size (n) to the url if configured
+func withPageSize(rawUrl string) string {
+	if PageSize <= 0 {
+		return rawUrl
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+
+	values := u.Query()
+	values.Set("n", strconv.Itoa(PageSize))
+	u.RawQuery = values.Encode()
+
+	return u.String()
+}
+
+// Requests the url and follows the Link header (rel="next") as
+// long as there is one, calling fn with the body of every page.
+// See: https://github.com/opencontainers/distribution-spec/blob/main/spec.md#listing-tags
+func (r *Registry) paginate(ctx context.Context, pageUrl string, scopes []string, fn func([]byte) error) error {
+	for pageUrl != "" {
+		log.Debug().Str("host", r.Host).Str("url", pageUrl).Msg("getting page")
+		request, err := http.NewRequestWithContext(ctx, "GET", pageUrl, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := r.request(request, scopes...)
+		if err != nil {
+			return err
+		}
+
+		content, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if err = fn(content); err != nil {
+			return err
+		}
+
+		pageUrl, err = nextPageUrl(request.URL, resp.Header.Get("Link"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the url of the next page from the Link header or an empty
+// string if there is no next page. Relative urls are resolved
+// against the url of the current page.
+func nextPageUrl(current *url.URL, link string) (string, error) {
+	// Link: </v2/_catalog?last=b&n=100>; rel="next"
+	for _, entry := range strings.Split(link, ",") {
+		target, params, found := strings.Cut(entry, ";")
+		if !found {
+			continue
+		}
+
+		isNext := false
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if key == "rel" && strings.Trim(value, `"`) == "next" {
+				isNext = true
+			}
+		}
+		if !isNext {
+			continue
+		}
+
+		target = strings.TrimSpace(target)
+		target = strings.TrimPrefix(target, "<")
+		target = strings.TrimSuffix(target, ">")
+
+		next, err := current.Parse(target)
+		if err != nil {
+			return "", err
+		}
+		return next.String(), nil
+	}
+
+	return "", nil
+}
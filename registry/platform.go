@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Platform describes the os/architecture an image
+// was built for as found in OCI image indexes and
+// docker manifest lists.
+type Platform struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+}
+
+type InvalidPlatform string
+
+func (s InvalidPlatform) Error() string {
+	return fmt.Sprintf("'%s' is not a valid platform (os/arch[/variant])", string(s))
+}
+
+// Parses platforms given as os/arch[/variant] e.g. linux/arm64/v8
+func ParsePlatform(s string) (*Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, InvalidPlatform(s)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return nil, InvalidPlatform(s)
+		}
+	}
+
+	p := &Platform{
+		OS:           parts[0],
+		Architecture: parts[1],
+	}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	return p, nil
+}
+
+// Returns true if this platform satisfies the wanted one.
+// The variant is only compared when the wanted platform has one.
+func (p *Platform) Matches(wanted *Platform) bool {
+	if p.OS != wanted.OS || p.Architecture != wanted.Architecture {
+		return false
+	}
+
+	return wanted.Variant == "" || p.Variant == wanted.Variant
+}
+
+// Some indexes (e.g. with build attestations) contain entries
+// that are not images for a real platform.
+func (p *Platform) IsUnknown() bool {
+	return p.OS == "unknown" || p.Architecture == "unknown"
+}
+
+func (p Platform) String() string {
+	// schema1 manifests only know the architecture
+	if p.OS == "" {
+		return p.Architecture
+	}
+
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
@@ -1,24 +1,17 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
-	"sync"
 
 	"github.com/rs/zerolog/log"
 )
 
-const maxParallelRequests = 5
-
-// a map that stores the throtteling channel to use
-// per host so that we don't create multiple registries
-// and end up spamming the host again.
-var throttleChanByHost sync.Map
-
 type catalogResponse struct {
 	Repositories []string `json:"repositories"`
 }
@@ -28,27 +21,121 @@ type tagListResponse struct {
 	Tags []string `json:"tags"`
 }
 
+const (
+	MediaTypeDockerSchema1         = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeDockerSchema1Signed   = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeDockerSchema2Manifest = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList    = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest           = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex              = "application/vnd.oci.image.index.v1+json"
+)
+
+// the manifest formats we understand in the order we prefer them
+var acceptedManifestMediaTypes = []string{
+	MediaTypeOCIIndex,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeDockerSchema2Manifest,
+	MediaTypeDockerSchema1Signed,
+	MediaTypeDockerSchema1,
+}
+
+// Descriptor references content (config, layers) by digest.
+// It is shared by the OCI and the docker schema2 manifest.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// only set for the entries of an index
+	Platform *Platform `json:"platform,omitempty"`
+}
+
+// Manifest is a union of the docker schema1, docker schema2,
+// the OCI image manifest and the indexes (OCI image index and docker
+// manifest list). Which fields are set depends on the SchemaVersion
+// and the MediaType.
 type Manifest struct {
 	SchemaVersion int    `json:"schemaVersion"`
-	Name          string `json:"name"`
-	Tag           string `json:"tag"`
-	Architecture  string `json:"architecture"`
-	FsLayers      []struct {
+	MediaType     string `json:"mediaType"`
+
+	// docker schema1
+	Name         string `json:"name"`
+	Tag          string `json:"tag"`
+	Architecture string `json:"architecture"`
+	FsLayers     []struct {
 		BlobSum string `json:"blobSum"`
 	} `json:"fsLayers"`
 	History []struct {
 		V1Compatibility string `json:"v1Compatibility"`
 	} `json:"history"`
 	// NOTE: signatures is ignored at the moment
+
+	// the digest of the manifest; not part of the manifest itself
+	Digest string `json:"-"`
+	// the manifest as returned by the registry
+	Raw json.RawMessage `json:"-"`
+
+	// docker schema2 and OCI
+	Config      *Descriptor       `json:"config"`
+	Layers      []Descriptor      `json:"layers"`
+	Annotations map[string]string `json:"annotations"`
+
+	// OCI index and docker manifest list
+	Manifests []Descriptor `json:"manifests"`
+}
+
+// Returns true if this is a docker schema1 manifest
+func (m *Manifest) IsSchema1() bool {
+	return m.SchemaVersion == 1
+}
+
+// Returns true if this manifest references one manifest per platform
+func (m *Manifest) IsIndex() bool {
+	return m.MediaType == MediaTypeOCIIndex || m.MediaType == MediaTypeDockerManifestList
+}
+
+// Returns the index entries matching the platform. If platform is nil
+// all entries for real platforms are returned.
+func (m *Manifest) PlatformManifests(platform *Platform) []Descriptor {
+	matching := make([]Descriptor, 0, len(m.Manifests))
+	for _, desc := range m.Manifests {
+		if desc.Platform == nil || desc.Platform.IsUnknown() {
+			continue
+		}
+		if platform != nil && !desc.Platform.Matches(platform) {
+			continue
+		}
+		matching = append(matching, desc)
+	}
+	return matching
+}
+
+// Returns the digests of all layers with the base layer first
+// regardless of the manifest format.
+func (m *Manifest) LayerDigests() []string {
+	if m.IsSchema1() {
+		// schema1 lists the layers from the top most to the base layer
+		layers := make([]string, 0, len(m.FsLayers))
+		for i := len(m.FsLayers) - 1; i >= 0; i-- {
+			layers = append(layers, m.FsLayers[i].BlobSum)
+		}
+		return layers
+	}
+
+	layers := make([]string, 0, len(m.Layers))
+	for _, layer := range m.Layers {
+		layers = append(layers, layer.Digest)
+	}
+	return layers
 }
 
 type Registry struct {
+	// the host as used in image names
 	Host string
-	auth authorizer
-	// this cannel is used like n-locks. N is determined by
-	// the buffer size and allows max n goroutines to
-	// perform parallel requests. Others have to wait...
-	throttleChan chan any
+	// the mirrors in the order they are tried, upstream is the last one
+	endpoints []*endpoint
 }
 
 var ErrImageDoesNotExist = errors.New("image does not exist")
@@ -58,139 +145,248 @@ var ErrNotAllowedOrUnavailable = errors.New("your're either not allowed to acces
 func buildUrl(host, endpoint string) string {
 	host = strings.TrimSuffix(host, "/")
 	endpoint = strings.TrimPrefix(endpoint, "/")
-	return fmt.Sprintf("https://%s/%s", host, endpoint)
+	return fmt.Sprintf("%s://%s/%s", schemeOf(host), host, endpoint)
 }
 
-func NewRegisty(host string) (*Registry, error) {
-	creds, found := credentialLookupTable[host]
-	if !found {
-		return nil, fmt.Errorf("no credentials for %s", host)
+// Creates a registry client for the host. The host may be an alias
+// (e.g. docker.io) of the host serving the registry. Pulls are served
+// by the mirrors of the host if there are any (see HostConfig). Mirrors
+// which can't be reached are skipped.
+func NewRegisty(ctx context.Context, host string) (*Registry, error) {
+	upstreamHost := resolveAlias(host)
+
+	endpoints := make([]*endpoint, 0, 1)
+	for _, mirror := range mirrorsOf(host) {
+		e, err := newEndpoint(ctx, mirror)
+		if err != nil {
+			log.Debug().Str("host", host).Str("mirror", mirror).Err(err).Msg("skipping mirror")
+			continue
+		}
+		endpoints = append(endpoints, e)
 	}
 
-	resp, err := http.Head(buildUrl(host, "v2/_catalog"))
+	upstream, err := newEndpoint(ctx, upstreamHost, aliasesOf(upstreamHost)...)
 	if err != nil {
 		return nil, err
 	}
-
-	wwwAuth := resp.Header.Get("Www-authenticate")
-
-	if resp.StatusCode != 401 || wwwAuth == "" {
-		return nil, fmt.Errorf("expected auth challenge from registry, but got: %s", resp.Status)
-	}
-
-	// if another registry for the same host exists, use the same throttling channel
-	throttleChan, _ := throttleChanByHost.LoadOrStore(host, make(chan any, maxParallelRequests))
+	endpoints = append(endpoints, upstream)
 
 	return &Registry{
-		Host:         host,
-		auth:         oauthAuthorizerFromChallenge(wwwAuth, creds),
-		throttleChan: throttleChan.(chan any),
+		Host:      host,
+		endpoints: endpoints,
 	}, nil
 }
 
-// makes the request and performs some common error checking
-// and retry logic
-// TODO: see error handling: https://github.com/opencontainers/distribution-spec/blob/main/spec.md
-func (r *Registry) request(request *http.Request) (*http.Response, error) {
-	request.Header.Set("Accept-Encoding", "*")
+// Returns how many requests to this registry may run in parallel.
+// Fan-outs should not use more workers than that.
+func (r *Registry) Concurrency() int {
+	return r.upstream().scheduler.concurrency()
+}
 
-	// Send something into the channel. Either it blocks and we have to
-	// wait until it is free or we can request right away and read from
-	// it later to unblock. (chan = n locks)
-	r.throttleChan <- nil
-	log.Debug().Str("host", r.Host).Str("url", request.RequestURI)
-	resp, err := http.DefaultClient.Do(request)
-	<-r.throttleChan
+func (r *Registry) upstream() *endpoint {
+	return r.endpoints[len(r.endpoints)-1]
+}
 
+// Checks that the registry is reachable. See VerifyCredentials for
+// checking the credentials.
+func (r *Registry) Ping(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", buildUrl(r.Host, "v2/"), nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if resp.StatusCode == 401 {
-		resp.Body.Close()
+	resp, err := r.request(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
 
-		// retry one more time with fresh token
-		r.auth.authorizeRequest(request)
-		resp, err = http.DefaultClient.Do(request)
-		if err != nil {
-			return nil, err
+	return nil
+}
+
+// Checks that the registry (not a mirror) accepts the credentials
+// configured for it
+func (r *Registry) VerifyCredentials(ctx context.Context) error {
+	return r.upstream().verifyCredentials(ctx)
+}
+
+// makes the request authorized for the scopes. The url is expected to
+// point to r.Host and is rewritten to the endpoints which are tried
+// in order. Unsuccessful responses are returned as *RegistryError.
+func (r *Registry) request(request *http.Request, scopes ...string) (*http.Response, error) {
+	endpoints := r.endpointsFor(request)
+
+	var resp *http.Response
+	var err error
+	for i, e := range endpoints {
+		resp, err = e.request(r.rewrite(request, e), scopes)
+		if err == nil || request.Context().Err() != nil || i == len(endpoints)-1 {
+			break
 		}
+		log.Debug().Str("host", r.Host).Str("mirror", e.host).Err(err).Msg("falling back to next endpoint")
+	}
+
+	return resp, err
+}
 
-		if resp.StatusCode == 401 {
-			resp.Body.Close()
-			// NOTE: in the Www-authenticate should be an error field that says so
-			return nil, ErrNotAllowedOrUnavailable
+// Mirrors only serve pulls. Urls of an endpoint (e.g. the next
+// page of a tag list) stay with that endpoint.
+func (r *Registry) endpointsFor(request *http.Request) []*endpoint {
+	for _, e := range r.endpoints {
+		if request.URL.Host == e.host && e.host != r.Host {
+			return []*endpoint{e}
 		}
 	}
-	if resp.StatusCode == 404 {
-		resp.Body.Close()
-		return nil, ErrResourceDoesNotExist
+
+	path := request.URL.Path
+	if !isIdempotent(request) || path == "/v2/" || path == "/v2/_catalog" {
+		return []*endpoint{r.upstream()}
 	}
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("unknown error: %s", resp.Status)
+	return r.endpoints
+}
+
+// Returns a copy of the request pointing to the endpoint
+func (r *Registry) rewrite(request *http.Request, e *endpoint) *http.Request {
+	if request.URL.Host != r.Host || e.host == r.Host {
+		return request
 	}
 
-	return resp, nil
+	rewritten := request.Clone(request.Context())
+	rewritten.URL.Scheme = schemeOf(e.host)
+	rewritten.URL.Host = e.host
+	rewritten.Host = ""
+	return rewritten
 }
 
-func (r *Registry) GetCatalog() ([]string, error) {
-	catalogUrl := buildUrl(r.Host, "v2/_catalog")
-	log.Debug().Str("host", r.Host).Msg("getting catalog")
-	request, err := http.NewRequest("GET", catalogUrl, nil)
+// Returns all repositories of the registry. Use WalkCatalog
+// to process huge catalogs page by page instead.
+func (r *Registry) GetCatalog(ctx context.Context) ([]string, error) {
+	repositories := make([]string, 0)
+	err := r.WalkCatalog(ctx, func(page []string) error {
+		repositories = append(repositories, page...)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if err = r.auth.authorizeRequest(request); err != nil {
-		return nil, err
+	return repositories, nil
+}
+
+// Calls fn with every page of the catalog as returned by the registry.
+// Returning an error from fn stops the walk and returns that error.
+func (r *Registry) WalkCatalog(ctx context.Context, fn func(page []string) error) error {
+	cacheKey := "catalog " + r.Host
+	if repositories, found := cache.getList(cacheKey); found {
+		return fn(repositories)
 	}
 
-	resp, err := r.request(request)
-	if err != nil {
-		if errors.Is(err, ErrNotAllowedOrUnavailable) {
-			return nil, fmt.Errorf("you don't seem to have permission to request the image catalog of the registry %s", r.Host)
+	catalogUrl := withPageSize(buildUrl(r.Host, "v2/_catalog"))
+	log.Debug().Str("host", r.Host).Msg("getting catalog")
+
+	recorder := cache.recordList()
+	err := r.paginate(ctx, catalogUrl, []string{catalogScope}, func(content []byte) error {
+		var cResp catalogResponse
+		if err := json.Unmarshal(content, &cResp); err != nil {
+			return err
 		}
-		return nil, err
+		recorder.add(cResp.Repositories)
+		return fn(cResp.Repositories)
+	})
+	if err == nil {
+		cache.putRecordedList(cacheKey, recorder)
 	}
 
-	defer resp.Body.Close()
+	if errors.Is(err, ErrNotAllowedOrUnavailable) {
+		return fmt.Errorf("you don't seem to have permission to request the image catalog of the registry %s: %w", r.Host, err)
+	}
+	return err
+}
 
-	content, err := io.ReadAll(resp.Body)
+// Returns all tags of the image. Use WalkTags to process
+// the tags page by page instead.
+func (r *Registry) GetTags(ctx context.Context, imageName string) ([]string, error) {
+	tags := make([]string, 0)
+	err := r.WalkTags(ctx, imageName, func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var cResp catalogResponse
-	if err = json.Unmarshal(content, &cResp); err != nil {
-		return nil, err
+	return tags, nil
+}
+
+// Calls fn with every page of the tag list as returned by the registry.
+// Returning an error from fn stops the walk and returns that error.
+func (r *Registry) WalkTags(ctx context.Context, imageName string, fn func(page []string) error) error {
+	imageName = strings.TrimSuffix(imageName, "/")
+	imageName = strings.TrimPrefix(imageName, "/")
+
+	cacheKey := "tags " + r.Host + "/" + imageName
+	if tags, found := cache.getList(cacheKey); found {
+		return fn(tags)
 	}
 
-	return cResp.Repositories, nil
+	tagListUrl := withPageSize(buildUrl(r.Host, fmt.Sprintf("v2/%s/tags/list", imageName)))
+	log.Debug().Str("host", r.Host).Str("image", imageName).Msg("getting tags")
+
+	recorder := cache.recordList()
+	err := r.paginate(ctx, tagListUrl, []string{repositoryScope(imageName, "pull")}, func(content []byte) error {
+		var tagsResp tagListResponse
+		if err := json.Unmarshal(content, &tagsResp); err != nil {
+			return err
+		}
+		recorder.add(tagsResp.Tags)
+		return fn(tagsResp.Tags)
+	})
+	if err == nil {
+		cache.putRecordedList(cacheKey, recorder)
+	}
+
+	return err
 }
 
-func (r *Registry) GetTags(imageName string) ([]string, error) {
+// Returns the manifest for the reference which is either a tag or a digest.
+// The content is verified against the requested digest and the digest
+// the registry claims (Docker-Content-Digest). If the cache is enabled
+// tags are resolved to digests first so that cached manifests are reused.
+func (r *Registry) GetManifest(ctx context.Context, imageName string, reference string) (*Manifest, error) {
 	imageName = strings.TrimSuffix(imageName, "/")
 	imageName = strings.TrimPrefix(imageName, "/")
 
-	tagListUrl := buildUrl(r.Host, fmt.Sprintf("v2/%s/tags/list", imageName))
-	log.Debug().Str("host", r.Host).Str("image", imageName).Msg("getting tags")
-	request, err := http.NewRequest("GET", tagListUrl, nil)
-	if err != nil {
-		return nil, err
+	if cache != nil && !IsDigest(reference) {
+		digest, err := r.resolveDigest(ctx, imageName, reference)
+		if err != nil {
+			return nil, err
+		}
+		if digest != "" {
+			reference = digest
+		}
 	}
 
-	if err = r.auth.authorizeRepoPull(request, imageName); err != nil {
-		return nil, err
+	if IsDigest(reference) {
+		if content, found := cache.getContent(reference); found {
+			manifest, err := parseManifest(content, "", reference, "")
+			if err == nil {
+				return manifest, nil
+			}
+			log.Debug().Str("digest", reference).Err(err).Msg("ignoring bad cache entry")
+		}
 	}
 
-	resp, err := r.request(request)
+	manifestUrl := buildUrl(r.Host, fmt.Sprintf("v2/%s/manifests/%s", imageName, reference))
+	log.Debug().Str("host", r.Host).Str("image", imageName).Str("reference", reference).Msg("getting manifest")
+	request, err := http.NewRequestWithContext(ctx, "GET", manifestUrl, nil)
 	if err != nil {
 		return nil, err
 	}
+	request.Header.Set("Accept", strings.Join(acceptedManifestMediaTypes, ", "))
 
-	if resp.StatusCode == 404 {
-		return nil, ErrImageDoesNotExist
+	resp, err := r.request(request, repositoryScope(imageName, "pull"))
+	if err != nil {
+		return nil, err
 	}
 
 	defer resp.Body.Close()
@@ -200,64 +396,97 @@ func (r *Registry) GetTags(imageName string) ([]string, error) {
 		return nil, err
 	}
 
-	var tagsResp tagListResponse
-	if err = json.Unmarshal(content, &tagsResp); err != nil {
-		return nil, err
+	manifest, err := parseManifest(content, resp.Header.Get("Content-Type"), reference, resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		return nil, fmt.Errorf("manifest of %s:%s: %w", imageName, reference, err)
 	}
 
-	return tagsResp.Tags, nil
-}
+	cache.putContent(manifest.Digest, content)
 
-func (r *Registry) GetManifest(imageName string, tag string) (*Manifest, error) {
-	imageName = strings.TrimSuffix(imageName, "/")
-	imageName = strings.TrimPrefix(imageName, "/")
-
-	manifestUrl := buildUrl(r.Host, fmt.Sprintf("v2/%s/manifests/%s", imageName, tag))
-	log.Debug().Str("host", r.Host).Str("image", imageName).Str("tag", tag).Msg("getting manifest")
-	request, err := http.NewRequest("GET", manifestUrl, nil)
-	if err != nil {
-		return nil, err
-	}
+	return manifest, nil
+}
 
-	if err = r.auth.authorizeRepoPull(request, imageName); err != nil {
+// Parses and verifies the manifest. contentType and advertisedDigest
+// are taken from the response headers and may be empty.
+func parseManifest(content []byte, contentType, reference, advertisedDigest string) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
 		return nil, err
 	}
 
-	resp, err := r.request(request)
-	if err != nil {
-		return nil, err
+	// older manifests don't carry their media type in the body
+	if manifest.MediaType == "" {
+		contentType, _, _ = strings.Cut(contentType, ";")
+		manifest.MediaType = strings.TrimSpace(contentType)
 	}
 
-	defer resp.Body.Close()
-
-	content, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	switch {
+	case manifest.IsSchema1():
+		// without a content type (e.g. from the cache) the body tells
+		if manifest.MediaType == "" {
+			manifest.MediaType = MediaTypeDockerSchema1
+			if strings.Contains(string(content), `"signatures"`) {
+				manifest.MediaType = MediaTypeDockerSchema1Signed
+			}
+		}
+	case manifest.MediaType == MediaTypeOCIManifest, manifest.MediaType == MediaTypeDockerSchema2Manifest:
+	case manifest.IsIndex():
+	// OCI manifests and indexes are allowed to omit the media type
+	case manifest.SchemaVersion == 2 && manifest.Config != nil:
+		manifest.MediaType = MediaTypeOCIManifest
+	case manifest.SchemaVersion == 2 && manifest.Manifests != nil:
+		manifest.MediaType = MediaTypeOCIIndex
+	default:
+		return nil, fmt.Errorf("unsupported manifest type '%s'", manifest.MediaType)
 	}
 
-	var manifest Manifest
-	if err = json.Unmarshal(content, &manifest); err != nil {
+	var err error
+	if manifest.Digest, err = manifestDigest(&manifest, reference, advertisedDigest, content); err != nil {
 		return nil, err
 	}
+	manifest.Raw = content
 
 	return &manifest, nil
 }
 
-func (r *Registry) Exists(imageName string, tag string) (bool, error) {
-	imageName = strings.TrimSuffix(imageName, "/")
-	imageName = strings.TrimPrefix(imageName, "/")
+// determines the digest of the manifest and verifies that it
+// matches the requested and the advertised digest
+func manifestDigest(manifest *Manifest, reference, advertised string, content []byte) (string, error) {
+	// the digest of signed schema1 manifests is calculated without
+	// the signatures so the registry has to be trusted
+	if manifest.MediaType == MediaTypeDockerSchema1Signed {
+		if IsDigest(reference) {
+			return reference, nil
+		}
+		return advertised, nil
+	}
 
-	manifestUrl := buildUrl(r.Host, fmt.Sprintf("v2/%s/manifests/%s", imageName, tag))
-	request, err := http.NewRequest("HEAD", manifestUrl, nil)
-	if err != nil {
-		return false, err
+	expected := []string{}
+	if IsDigest(reference) {
+		expected = append(expected, reference)
+	}
+	if advertised != "" {
+		expected = append(expected, advertised)
 	}
 
-	if err = r.auth.authorizeRepoPull(request, imageName); err != nil {
-		return false, err
+	for _, digest := range expected {
+		if err := verifyDigest(digest, content); err != nil {
+			return "", err
+		}
 	}
 
-	resp, err := r.request(request)
+	if len(expected) != 0 {
+		return expected[0], nil
+	}
+	return ComputeDigest("sha256", content)
+}
+
+// Returns true if a manifest for the reference (tag or digest) exists
+func (r *Registry) Exists(ctx context.Context, imageName string, reference string) (bool, error) {
+	resp, err := r.headManifest(ctx, imageName, reference)
+	if errors.Is(err, ErrResourceDoesNotExist) {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
@@ -266,3 +495,29 @@ func (r *Registry) Exists(imageName string, tag string) (bool, error) {
 
 	return resp.StatusCode == 200, nil
 }
+
+// Returns the digest the tag points to or an empty
+// string if the registry does not tell.
+func (r *Registry) resolveDigest(ctx context.Context, imageName string, tag string) (string, error) {
+	resp, err := r.headManifest(ctx, imageName, tag)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+func (r *Registry) headManifest(ctx context.Context, imageName string, reference string) (*http.Response, error) {
+	imageName = strings.TrimSuffix(imageName, "/")
+	imageName = strings.TrimPrefix(imageName, "/")
+
+	manifestUrl := buildUrl(r.Host, fmt.Sprintf("v2/%s/manifests/%s", imageName, reference))
+	request, err := http.NewRequestWithContext(ctx, "HEAD", manifestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", strings.Join(acceptedManifestMediaTypes, ", "))
+
+	return r.request(request, repositoryScope(imageName, "pull"))
+}
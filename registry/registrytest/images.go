@@ -0,0 +1,152 @@
+package registrytest
+
+import (
+	"encoding/json"
+
+	"github.com/sojamann/ocapi/registry"
+)
+
+const (
+	mediaTypeOCIConfig    = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer     = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaTypeDockerConfig = "application/vnd.docker.container.image.v1+json"
+	mediaTypeDockerLayer  = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// Returns a minimal image config for the platform
+func Config(os, architecture string) []byte {
+	content, _ := json.Marshal(map[string]any{
+		"architecture": architecture,
+		"os":           os,
+		"config":       map[string]any{},
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": []string{}},
+	})
+	return content
+}
+
+// Pushes the config and the layers as blobs and an OCI manifest
+// referencing them. A nil config is replaced by a minimal one.
+// Layers are given with the base layer first.
+func (r *Registry) PushOCIImage(repo, tag string, config []byte, layers ...[]byte) string {
+	return r.pushImage(repo, tag, registry.MediaTypeOCIManifest, mediaTypeOCIConfig, mediaTypeOCILayer, config, layers)
+}
+
+// Like PushOCIImage but with a docker schema2 manifest
+func (r *Registry) PushSchema2Image(repo, tag string, config []byte, layers ...[]byte) string {
+	return r.pushImage(repo, tag, registry.MediaTypeDockerSchema2Manifest, mediaTypeDockerConfig, mediaTypeDockerLayer, config, layers)
+}
+
+func (r *Registry) pushImage(repo, tag, mediaType, configType, layerType string, config []byte, layers [][]byte) string {
+	if config == nil {
+		config = Config("linux", "amd64")
+	}
+
+	manifest := registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaType,
+		Config: &registry.Descriptor{
+			MediaType: configType,
+			Digest:    r.PushBlob(repo, config),
+			Size:      int64(len(config)),
+		},
+		Layers: make([]registry.Descriptor, 0, len(layers)),
+	}
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, registry.Descriptor{
+			MediaType: layerType,
+			Digest:    r.PushBlob(repo, layer),
+			Size:      int64(len(layer)),
+		})
+	}
+
+	return r.PushManifest(repo, tag, mediaType, marshalManifest(manifest))
+}
+
+// Pushes the layers as blobs and an unsigned docker schema1
+// manifest. Layers are given with the base layer first.
+func (r *Registry) PushSchema1Image(repo, tag, architecture string, layers ...[]byte) string {
+	type fsLayer struct {
+		BlobSum string `json:"blobSum"`
+	}
+	type history struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	}
+
+	fsLayers := make([]fsLayer, 0, len(layers))
+	histories := make([]history, 0, len(layers))
+	// schema1 lists the top most layer first
+	for i := len(layers) - 1; i >= 0; i-- {
+		fsLayers = append(fsLayers, fsLayer{r.PushBlob(repo, layers[i])})
+		histories = append(histories, history{"{}"})
+	}
+
+	content, _ := json.Marshal(map[string]any{
+		"schemaVersion": 1,
+		"name":          repo,
+		"tag":           tag,
+		"architecture":  architecture,
+		"fsLayers":      fsLayers,
+		"history":       histories,
+	})
+
+	return r.PushManifest(repo, tag, registry.MediaTypeDockerSchema1, content)
+}
+
+// IndexEntry references an already pushed manifest from an index
+type IndexEntry struct {
+	Digest   string
+	Platform registry.Platform
+}
+
+// Pushes an index (registry.MediaTypeOCIIndex or
+// registry.MediaTypeDockerManifestList) of the entries
+func (r *Registry) PushIndex(repo, tag, mediaType string, entries ...IndexEntry) string {
+	manifestType := registry.MediaTypeOCIManifest
+	if mediaType == registry.MediaTypeDockerManifestList {
+		manifestType = registry.MediaTypeDockerSchema2Manifest
+	}
+
+	index := registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaType,
+		Manifests:     make([]registry.Descriptor, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		platform := entry.Platform
+
+		r.mutex.Lock()
+		size := len(r.repo(repo).manifests[entry.Digest].content)
+		r.mutex.Unlock()
+
+		index.Manifests = append(index.Manifests, registry.Descriptor{
+			MediaType: manifestType,
+			Digest:    entry.Digest,
+			Size:      int64(size),
+			Platform:  &platform,
+		})
+	}
+
+	return r.PushManifest(repo, tag, mediaType, marshalManifest(index))
+}
+
+// only marshals the fields of the schema2/OCI formats
+func marshalManifest(m registry.Manifest) []byte {
+	type manifest struct {
+		SchemaVersion int                   `json:"schemaVersion"`
+		MediaType     string                `json:"mediaType"`
+		Config        *registry.Descriptor  `json:"config,omitempty"`
+		Layers        []registry.Descriptor `json:"layers,omitempty"`
+		Manifests     []registry.Descriptor `json:"manifests,omitempty"`
+		Annotations   map[string]string     `json:"annotations,omitempty"`
+	}
+
+	content, _ := json.Marshal(manifest{
+		SchemaVersion: m.SchemaVersion,
+		MediaType:     m.MediaType,
+		Config:        m.Config,
+		Layers:        m.Layers,
+		Manifests:     m.Manifests,
+		Annotations:   m.Annotations,
+	})
+	return content
+}
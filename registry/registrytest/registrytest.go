@@ -0,0 +1,624 @@
+// Package registrytest provides an in-process OCI registry for tests.
+//
+// The registry serves the catalog, tag lists (with pagination), manifests
+// of every supported format, blobs and the token endpoint of the bearer
+// token flow. Faults like 429, 5xx or slow responses can be injected to
+// exercise the retry logic.
+//
+// The package imports registry for its manifest types, so tests of the
+// registry package itself must be external (package registry_test) to
+// use it; internal tests would create an import cycle.
+package registrytest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AuthMode int
+
+const (
+	// every request is allowed
+	AuthNone AuthMode = iota
+	// HTTP basic authentication with the configured credentials
+	AuthBasic
+	// bearer tokens optained from the /token endpoint
+	AuthBearer
+)
+
+const Service = "registrytest"
+
+type manifest struct {
+	mediaType string
+	content   []byte
+}
+
+type repository struct {
+	// tag -> digest
+	tags      map[string]string
+	manifests map[string]manifest
+	blobs     map[string][]byte
+}
+
+// Fault makes matching requests fail or slow
+type Fault struct {
+	// only requests whose path starts with the prefix are affected
+	PathPrefix string
+	// only requests with this method are affected; empty means all
+	Method string
+	// the status to respond with; 0 means the request is served
+	// normally (useful together with Delay)
+	Status int
+	// sent as Retry-After header if set
+	RetryAfter string
+	// time to wait before responding
+	Delay time.Duration
+	// how often the fault is applied; 0 means always
+	Times int
+	// replaces the body of a response that is served normally
+	Body []byte
+	// replaces the Docker-Content-Digest header of a response
+	// that is served normally
+	DigestHeader string
+}
+
+// applies the Body and DigestHeader of a fault to the response
+type faultyResponseWriter struct {
+	http.ResponseWriter
+	fault   *Fault
+	written bool
+}
+
+func (w *faultyResponseWriter) WriteHeader(status int) {
+	if w.fault.DigestHeader != "" {
+		w.Header().Set("Docker-Content-Digest", w.fault.DigestHeader)
+	}
+	if w.fault.Body != nil {
+		w.Header().Set("Content-Length", strconv.Itoa(len(w.fault.Body)))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *faultyResponseWriter) Write(content []byte) (int, error) {
+	if w.fault.Body == nil {
+		return w.ResponseWriter.Write(content)
+	}
+	if !w.written {
+		w.written = true
+		if _, err := w.ResponseWriter.Write(w.fault.Body); err != nil {
+			return 0, err
+		}
+	}
+	return len(content), nil
+}
+
+// Registry is a fake registry backed by an httptest.Server
+type Registry struct {
+	Server *httptest.Server
+
+	// number of entries per page if the client does not ask for one
+	PageSize int
+
+	auth     AuthMode
+	username string
+	password string
+	// refresh tokens accepted by the token endpoint
+	refreshTokens map[string]bool
+	// allows tokens without credentials
+	anonymous bool
+	// /v2/ is answered without authentication
+	publicPing bool
+
+	mutex    sync.Mutex
+	repos    map[string]*repository
+	tokens   map[string]grant
+	faults   []*Fault
+	requests []string
+}
+
+// a token handed out by the token endpoint
+type grant struct {
+	validUntil time.Time
+	// <type>:<name>:<action> the token is valid for
+	scopes map[string]bool
+}
+
+type Option func(*Registry)
+
+// Requires HTTP basic authentication
+func WithBasicAuth(username, password string) Option {
+	return func(r *Registry) {
+		r.auth = AuthBasic
+		r.username = username
+		r.password = password
+	}
+}
+
+// Requires bearer tokens which are handed out for the credentials.
+// If username is empty, anonymous tokens are handed out.
+func WithBearerAuth(username, password string) Option {
+	return func(r *Registry) {
+		r.auth = AuthBearer
+		r.username = username
+		r.password = password
+		r.anonymous = username == ""
+	}
+}
+
+// Makes the token endpoint accept the refresh token (identity token)
+func WithRefreshToken(refreshToken string) Option {
+	return func(r *Registry) {
+		r.refreshTokens[refreshToken] = true
+	}
+}
+
+// Answers /v2/ without authentication; only the
+// catalog and the repositories require it
+func WithPublicPing() Option {
+	return func(r *Registry) {
+		r.publicPing = true
+	}
+}
+
+// Sets the default page size of the catalog and tag lists
+func WithPageSize(n int) Option {
+	return func(r *Registry) {
+		r.PageSize = n
+	}
+}
+
+// Starts a new registry. Call Close when done.
+func New(opts ...Option) *Registry {
+	r := &Registry{
+		refreshTokens: make(map[string]bool),
+		repos:         make(map[string]*repository),
+		tokens:        make(map[string]grant),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+func (r *Registry) Close() {
+	r.Server.Close()
+}
+
+// Returns the host:port to use as registry host
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.Server.URL, "http://")
+}
+
+// Returns "METHOD path" of every request received so far
+func (r *Registry) Requests() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string{}, r.requests...)
+}
+
+// Adds a fault. Faults are checked in the order they were added.
+func (r *Registry) InjectFault(fault Fault) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.faults = append(r.faults, &fault)
+}
+
+func (r *Registry) repo(name string) *repository {
+	repo, found := r.repos[name]
+	if !found {
+		repo = &repository{
+			tags:      make(map[string]string),
+			manifests: make(map[string]manifest),
+			blobs:     make(map[string][]byte),
+		}
+		r.repos[name] = repo
+	}
+	return repo
+}
+
+// Stores the blob and returns its digest
+func (r *Registry) PushBlob(repo string, content []byte) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	digest := Digest(content)
+	r.repo(repo).blobs[digest] = content
+	return digest
+}
+
+// Stores the manifest, tags it if tag is not empty and returns its digest
+func (r *Registry) PushManifest(repo, tag, mediaType string, content []byte) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	digest := Digest(content)
+	rp := r.repo(repo)
+	rp.manifests[digest] = manifest{mediaType, content}
+	if tag != "" {
+		rp.tags[tag] = digest
+	}
+	return digest
+}
+
+// Removes the tag from the repository
+func (r *Registry) DeleteTag(repo, tag string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.repo(repo).tags, tag)
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+	fault := r.matchingFault(req)
+	r.mutex.Unlock()
+
+	if fault != nil {
+		if fault.Delay > 0 {
+			select {
+			case <-time.After(fault.Delay):
+			case <-req.Context().Done():
+				return
+			}
+		}
+		if fault.Status != 0 {
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			code := "UNKNOWN"
+			if fault.Status == http.StatusTooManyRequests {
+				code = "TOOMANYREQUESTS"
+			}
+			writeError(w, fault.Status, code, http.StatusText(fault.Status))
+			return
+		}
+		if fault.Body != nil || fault.DigestHeader != "" {
+			w = &faultyResponseWriter{ResponseWriter: w, fault: fault}
+		}
+	}
+
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+
+	if !strings.HasPrefix(req.URL.Path, "/v2/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case path == "":
+		if r.authorize(w, req, "") {
+			w.WriteHeader(http.StatusOK)
+		}
+	case path == "_catalog":
+		if r.authorize(w, req, "registry:catalog:*") {
+			r.serveCatalog(w, req)
+		}
+	case strings.HasSuffix(path, "/tags/list"):
+		name := strings.TrimSuffix(path, "/tags/list")
+		if r.authorize(w, req, "repository:"+name+":pull") {
+			r.serveTags(w, req, name)
+		}
+	case strings.Contains(path, "/manifests/"):
+		idx := strings.LastIndex(path, "/manifests/")
+		name, reference := path[:idx], path[idx+len("/manifests/"):]
+		if r.authorize(w, req, "repository:"+name+":pull") {
+			r.serveManifest(w, req, name, reference)
+		}
+	case strings.Contains(path, "/blobs/"):
+		idx := strings.LastIndex(path, "/blobs/")
+		name, digest := path[:idx], path[idx+len("/blobs/"):]
+		if r.authorize(w, req, "repository:"+name+":pull") {
+			r.serveBlob(w, req, name, digest)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// must be called with the mutex held
+func (r *Registry) matchingFault(req *http.Request) *Fault {
+	for i, fault := range r.faults {
+		if !strings.HasPrefix(req.URL.Path, fault.PathPrefix) {
+			continue
+		}
+		if fault.Method != "" && fault.Method != req.Method {
+			continue
+		}
+
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				r.faults = append(r.faults[:i], r.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+// Checks the authorization and responds with a challenge if it is
+// missing. Returns true if the request may be served.
+func (r *Registry) authorize(w http.ResponseWriter, req *http.Request, scope string) bool {
+	if scope == "" && r.publicPing {
+		return true
+	}
+
+	switch r.auth {
+	case AuthBasic:
+		username, password, ok := req.BasicAuth()
+		if ok && username == r.username && password == r.password {
+			return true
+		}
+		w.Header().Set("Www-Authenticate", fmt.Sprintf(`Basic realm="%s"`, Service))
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return false
+
+	case AuthBearer:
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		r.mutex.Lock()
+		grant, found := r.tokens[token]
+		r.mutex.Unlock()
+		valid := found && time.Now().Before(grant.validUntil)
+		if valid && (scope == "" || grant.scopes[scope]) {
+			return true
+		}
+
+		challenge := fmt.Sprintf(`Bearer realm="%s/token",service="%s"`, r.Server.URL, Service)
+		if scope != "" {
+			challenge += fmt.Sprintf(`,scope="%s"`, scope)
+		}
+		if valid {
+			challenge += `,error="insufficient_scope"`
+		}
+		w.Header().Set("Www-Authenticate", challenge)
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return false
+	}
+
+	return true
+}
+
+// Implements the GET flow and the OAuth2 POST flow of
+// https://docs.docker.com/registry/spec/auth/token/
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "UNSUPPORTED", err.Error())
+		return
+	}
+
+	username, password, hasBasic := req.BasicAuth()
+	if !hasBasic {
+		username, password = req.Form.Get("username"), req.Form.Get("password")
+	}
+
+	var refreshToken string
+	switch {
+	case req.Method == "POST" && req.Form.Get("grant_type") == "refresh_token":
+		r.mutex.Lock()
+		valid := r.refreshTokens[req.Form.Get("refresh_token")]
+		r.mutex.Unlock()
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid refresh token")
+			return
+		}
+	case username != "" || password != "":
+		if username != r.username || password != r.password {
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+			return
+		}
+		if req.Method == "POST" && req.Form.Get("access_type") == "offline" {
+			refreshToken = randomString()
+			r.mutex.Lock()
+			r.refreshTokens[refreshToken] = true
+			r.mutex.Unlock()
+		}
+	case !r.anonymous:
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "credentials required")
+		return
+	}
+
+	token := randomString()
+	expiresIn := 300
+	issuedAt := time.Now()
+
+	r.mutex.Lock()
+	r.tokens[token] = grant{
+		validUntil: issuedAt.Add(time.Duration(expiresIn) * time.Second),
+		scopes:     grantedScopes(req.Form["scope"]),
+	}
+	r.mutex.Unlock()
+
+	writeJSON(w, "application/json", map[string]any{
+		"token":         token,
+		"access_token":  token,
+		"refresh_token": refreshToken,
+		"scope":         strings.Join(req.Form["scope"], " "),
+		"expires_in":    expiresIn,
+		"issued_at":     issuedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// Splits the requested scopes (space separated or given multiple
+// times) into one entry per action. All requested scopes are granted.
+func grantedScopes(requested []string) map[string]bool {
+	scopes := make(map[string]bool)
+	for _, scope := range strings.Fields(strings.Join(requested, " ")) {
+		i := strings.LastIndex(scope, ":")
+		if i == -1 {
+			continue
+		}
+		for _, action := range strings.Split(scope[i+1:], ",") {
+			scopes[scope[:i+1]+action] = true
+		}
+	}
+	return scopes
+}
+
+func (r *Registry) serveCatalog(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	names := make([]string, 0, len(r.repos))
+	for name := range r.repos {
+		names = append(names, name)
+	}
+	r.mutex.Unlock()
+
+	page, next := r.paginate(req, names)
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?%s>; rel="next"`, next))
+	}
+	writeJSON(w, "application/json", map[string]any{"repositories": page})
+}
+
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, name string) {
+	r.mutex.Lock()
+	repo, found := r.repos[name]
+	tags := make([]string, 0)
+	if found {
+		for tag := range repo.tags {
+			tags = append(tags, tag)
+		}
+	}
+	r.mutex.Unlock()
+
+	if !found {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+
+	page, next := r.paginate(req, tags)
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?%s>; rel="next"`, name, next))
+	}
+	writeJSON(w, "application/json", map[string]any{"name": name, "tags": page})
+}
+
+// returns the requested page (n, last) and the query of the next page
+func (r *Registry) paginate(req *http.Request, entries []string) ([]string, string) {
+	sort.Strings(entries)
+
+	n := r.PageSize
+	if value := req.URL.Query().Get("n"); value != "" {
+		n, _ = strconv.Atoi(value)
+	}
+
+	start := 0
+	if last := req.URL.Query().Get("last"); last != "" {
+		start = sort.SearchStrings(entries, last)
+		if start < len(entries) && entries[start] == last {
+			start++
+		}
+	}
+	entries = entries[start:]
+
+	if n <= 0 || n >= len(entries) {
+		return entries, ""
+	}
+
+	page := entries[:n]
+	next := url.Values{}
+	next.Set("n", strconv.Itoa(n))
+	next.Set("last", page[len(page)-1])
+	return page, next.Encode()
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, name, reference string) {
+	r.mutex.Lock()
+	var m manifest
+	found := false
+	digest := reference
+	if repo, ok := r.repos[name]; ok {
+		if tagged, ok := repo.tags[reference]; ok {
+			digest = tagged
+		}
+		m, found = repo.manifests[digest]
+	}
+	r.mutex.Unlock()
+
+	if !found {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+
+	w.Header().Set("Content-Type", m.mediaType)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.content)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		w.Write(m.content)
+	}
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, name, digest string) {
+	r.mutex.Lock()
+	var content []byte
+	found := false
+	if repo, ok := r.repos[name]; ok {
+		content, found = repo.blobs[digest]
+	}
+	r.mutex.Unlock()
+
+	if !found {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		w.Write(content)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, v any) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	content, _ := json.Marshal(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(content)
+}
+
+// Returns the sha256 digest of the content
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,364 @@
+package registrytest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sojamann/ocapi/registry"
+	"github.com/sojamann/ocapi/registry/registrytest"
+)
+
+func countRequests(fake *registrytest.Registry, request string) int {
+	n := 0
+	for _, r := range fake.Requests() {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func TestAuthModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []registrytest.Option
+		username string
+		password string
+		ok       bool
+	}{
+		{"none", nil, "", "", true},
+		{"basic", []registrytest.Option{registrytest.WithBasicAuth("user", "secret")}, "user", "secret", true},
+		{"basic without credentials", []registrytest.Option{registrytest.WithBasicAuth("user", "secret")}, "", "", false},
+		{"bearer", []registrytest.Option{registrytest.WithBearerAuth("user", "secret")}, "user", "secret", true},
+		{"bearer wrong password", []registrytest.Option{registrytest.WithBearerAuth("user", "secret")}, "user", "wrong", false},
+		{"bearer anonymous", []registrytest.Option{registrytest.WithBearerAuth("", "")}, "", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := registrytest.New(test.options...)
+			defer fake.Close()
+			digest := fake.PushOCIImage("lib/app", "1", nil, []byte("layer"))
+
+			if test.username != "" {
+				registry.SetCredentials(fake.Host(), test.username, test.password)
+			}
+			r, err := registry.NewRegisty(context.Background(), fake.Host())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			manifest, err := r.GetManifest(context.Background(), "lib/app", "1")
+			if !test.ok {
+				if err == nil {
+					t.Fatal("expected the request to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Digest != digest {
+				t.Fatalf("expected digest %s, got %s", digest, manifest.Digest)
+			}
+		})
+	}
+}
+
+func TestPagination(t *testing.T) {
+	pageSize := registry.PageSize
+	registry.PageSize = 0
+	defer func() { registry.PageSize = pageSize }()
+
+	fake := registrytest.New(registrytest.WithPageSize(2))
+	defer fake.Close()
+	for _, repo := range []string{"a", "b", "c", "d", "e"} {
+		fake.PushOCIImage("lib/"+repo, "1", nil, []byte(repo))
+	}
+	for _, tag := range []string{"2", "3", "4"} {
+		fake.PushOCIImage("lib/a", tag, nil, []byte("a"))
+	}
+
+	r, err := registry.NewRegisty(context.Background(), fake.Host())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repositories, err := r.GetCatalog(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repositories) != 5 {
+		t.Fatalf("expected 5 repositories, got %v", repositories)
+	}
+	if pages := countRequests(fake, "GET /v2/_catalog"); pages != 3 {
+		t.Fatalf("expected 3 pages, got %d", pages)
+	}
+
+	tags, err := r.GetTags(context.Background(), "lib/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 4 {
+		t.Fatalf("expected 4 tags, got %v", tags)
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	delay := registry.RetryBaseDelay
+	registry.RetryBaseDelay = time.Millisecond
+	defer func() { registry.RetryBaseDelay = delay }()
+
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.PushOCIImage("lib/app", "1", nil, []byte("layer"))
+
+	r, err := registry.NewRegisty(context.Background(), fake.Host())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// transient faults are retried
+	fake.InjectFault(registrytest.Fault{PathPrefix: "/v2/lib/app/tags", Status: http.StatusTooManyRequests, RetryAfter: "0", Times: 1})
+	fake.InjectFault(registrytest.Fault{PathPrefix: "/v2/lib/app/tags", Status: http.StatusServiceUnavailable, Times: 1})
+	if _, err := r.GetTags(context.Background(), "lib/app"); err != nil {
+		t.Fatalf("expected the faults to be retried, got %v", err)
+	}
+	if attempts := countRequests(fake, "GET /v2/lib/app/tags/list"); attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+
+	// until the retries are used up
+	fake.InjectFault(registrytest.Fault{PathPrefix: "/v2/lib/app/manifests", Status: http.StatusBadGateway})
+	_, err = r.GetManifest(context.Background(), "lib/app", "1")
+	var regErr *registry.RegistryError
+	if !errors.As(err, &regErr) || regErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected a 502 error, got %v", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	amd64 := fake.PushOCIImage("lib/app", "", registrytest.Config("linux", "amd64"), []byte("amd64"))
+	arm64 := fake.PushOCIImage("lib/app", "", registrytest.Config("linux", "arm64"), []byte("arm64"))
+	fake.PushIndex("lib/app", "1", registry.MediaTypeOCIIndex,
+		registrytest.IndexEntry{Digest: amd64, Platform: registry.Platform{OS: "linux", Architecture: "amd64"}},
+		registrytest.IndexEntry{Digest: arm64, Platform: registry.Platform{OS: "linux", Architecture: "arm64"}},
+	)
+
+	r, err := registry.NewRegisty(context.Background(), fake.Host())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := r.GetManifest(context.Background(), "lib/app", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !index.IsIndex() {
+		t.Fatalf("expected an index, got %s", index.MediaType)
+	}
+
+	descriptors := index.PlatformManifests(&registry.Platform{OS: "linux", Architecture: "arm64"})
+	if len(descriptors) != 1 || descriptors[0].Digest != arm64 {
+		t.Fatalf("expected the arm64 manifest, got %v", descriptors)
+	}
+	if all := index.PlatformManifests(nil); len(all) != 2 {
+		t.Fatalf("expected both manifests, got %v", all)
+	}
+}
+
+func TestDigestMismatch(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	digest := fake.PushOCIImage("lib/app", "1", nil, []byte("layer"))
+	other := fake.PushOCIImage("lib/app", "2", nil, []byte("other"))
+	// a valid manifest, just not the pushed one
+	body := []byte(`{"schemaVersion":2,"mediaType":"` + registry.MediaTypeOCIManifest + `","layers":[]}`)
+
+	tests := []struct {
+		name      string
+		reference string
+		fault     registrytest.Fault
+		expected  string
+	}{
+		{"body does not match the pinned digest", digest, registrytest.Fault{Body: body}, digest},
+		{"wrong digest header", "1", registrytest.Fault{DigestHeader: other}, other},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := registry.NewRegisty(context.Background(), fake.Host())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.fault.PathPrefix = "/v2/lib/app/manifests/"
+			test.fault.Times = 1
+			fake.InjectFault(test.fault)
+
+			_, err = r.GetManifest(context.Background(), "lib/app", test.reference)
+			var mismatch registry.DigestMismatch
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("expected a digest mismatch, got %v", err)
+			}
+			if mismatch.Expected != test.expected {
+				t.Fatalf("expected %s to be expected, got %s", test.expected, mismatch.Expected)
+			}
+		})
+	}
+}
+
+// makes a request to the fake registry without the registry client
+func do(t *testing.T, fake *registrytest.Registry, method, path string, token string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, fake.Server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestHarnessManifests(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	content := []byte(`{"schemaVersion":2}`)
+	digest := fake.PushManifest("lib/app", "1", registry.MediaTypeOCIManifest, content)
+	if digest != registrytest.Digest(content) {
+		t.Fatalf("expected the digest of the content, got %s", digest)
+	}
+
+	resp := do(t, fake, "GET", "/v2/lib/app/manifests/1", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %s", resp.Status)
+	}
+	if header := resp.Header.Get("Docker-Content-Digest"); header != digest {
+		t.Fatalf("expected the digest header %s, got %s", digest, header)
+	}
+	if mediaType := resp.Header.Get("Content-Type"); mediaType != registry.MediaTypeOCIManifest {
+		t.Fatalf("expected the media type of the push, got %s", mediaType)
+	}
+
+	fake.DeleteTag("lib/app", "1")
+	if resp := do(t, fake, "GET", "/v2/lib/app/manifests/1", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the deleted tag to be unknown, got %s", resp.Status)
+	}
+	if resp := do(t, fake, "GET", "/v2/lib/app/manifests/"+digest, ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the manifest to remain, got %s", resp.Status)
+	}
+}
+
+func TestHarnessFaults(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.PushOCIImage("lib/app", "1", nil, []byte("layer"))
+
+	fake.InjectFault(registrytest.Fault{PathPrefix: "/v2/lib/app/manifests/", Method: "HEAD", Status: http.StatusServiceUnavailable, RetryAfter: "7", Times: 2})
+
+	if resp := do(t, fake, "GET", "/v2/lib/app/manifests/1", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected other methods to be served, got %s", resp.Status)
+	}
+	for i := 0; i < 2; i++ {
+		resp := do(t, fake, "HEAD", "/v2/lib/app/manifests/1", "")
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "7" {
+			t.Fatalf("expected the fault, got %s", resp.Status)
+		}
+	}
+	if resp := do(t, fake, "HEAD", "/v2/lib/app/manifests/1", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the fault to be used up, got %s", resp.Status)
+	}
+
+	want := []string{
+		"GET /v2/lib/app/manifests/1",
+		"HEAD /v2/lib/app/manifests/1",
+		"HEAD /v2/lib/app/manifests/1",
+		"HEAD /v2/lib/app/manifests/1",
+	}
+	if got := fake.Requests(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected the requests %v, got %v", want, got)
+	}
+}
+
+func TestHarnessPaginationLinks(t *testing.T) {
+	fake := registrytest.New(registrytest.WithPageSize(2))
+	defer fake.Close()
+	for _, tag := range []string{"1", "2", "3"} {
+		fake.PushOCIImage("lib/app", tag, nil, []byte(tag))
+	}
+
+	resp := do(t, fake, "GET", "/v2/lib/app/tags/list", "")
+	if link := resp.Header.Get("Link"); link != `</v2/lib/app/tags/list?last=2&n=2>; rel="next"` {
+		t.Fatalf("expected a link to the next page, got %q", link)
+	}
+	resp = do(t, fake, "GET", "/v2/lib/app/tags/list?last=2&n=2", "")
+	if link := resp.Header.Get("Link"); link != "" {
+		t.Fatalf("expected no link on the last page, got %q", link)
+	}
+}
+
+func TestHarnessTokens(t *testing.T) {
+	fake := registrytest.New(registrytest.WithBearerAuth("user", "secret"), registrytest.WithRefreshToken("refresh"))
+	defer fake.Close()
+	fake.PushOCIImage("lib/app", "1", nil, []byte("layer"))
+
+	resp := do(t, fake, "GET", "/v2/lib/app/tags/list", "")
+	challenge := resp.Header.Get("Www-Authenticate")
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(challenge, `scope="repository:lib/app:pull"`) {
+		t.Fatalf("expected a challenge for the pull scope, got %s %q", resp.Status, challenge)
+	}
+
+	requestToken := func(form url.Values) (int, map[string]any) {
+		resp, err := http.PostForm(fake.Server.URL+"/token", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body := map[string]any{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	status, body := requestToken(url.Values{
+		"grant_type": {"password"}, "username": {"user"}, "password": {"secret"},
+		"access_type": {"offline"}, "scope": {"repository:lib/app:pull,push"},
+	})
+	token, _ := body["token"].(string)
+	refreshToken, _ := body["refresh_token"].(string)
+	if status != http.StatusOK || token == "" || refreshToken == "" {
+		t.Fatalf("expected a token and a refresh token, got %d %v", status, body)
+	}
+	if resp := do(t, fake, "GET", "/v2/lib/app/tags/list", token); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the token to grant pull, got %s", resp.Status)
+	}
+	if resp := do(t, fake, "GET", "/v2/_catalog", token); resp.StatusCode != http.StatusUnauthorized ||
+		!strings.Contains(resp.Header.Get("Www-Authenticate"), `error="insufficient_scope"`) {
+		t.Fatalf("expected the catalog to need another scope, got %s", resp.Status)
+	}
+
+	for _, refreshToken := range []string{"refresh", refreshToken} {
+		if status, _ := requestToken(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}}); status != http.StatusOK {
+			t.Fatalf("expected the refresh token %s to be accepted, got %d", refreshToken, status)
+		}
+	}
+	if status, _ := requestToken(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"unknown"}}); status != http.StatusUnauthorized {
+		t.Fatalf("expected an unknown refresh token to be rejected, got %d", status)
+	}
+	if status, _ := requestToken(url.Values{"grant_type": {"password"}, "username": {"user"}, "password": {"wrong"}}); status != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong password to be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often an idempotent request (GET, HEAD) is retried
+// on network errors, 429 and 5xx responses.
+var MaxRetries = 3
+
+// The first backoff delay, it doubles with every attempt
+var RetryBaseDelay = 500 * time.Millisecond
+
+// The longest time to wait before retrying. If the registry asks
+// to wait longer (Retry-After) the request is not retried.
+var RetryMaxDelay = 30 * time.Second
+
+// waits for the duration or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func isIdempotent(request *http.Request) bool {
+	return request.Method == "GET" || request.Method == "HEAD"
+}
+
+// Returns how long to wait before retrying the request that resulted
+// in resp or err and if it should be retried at all.
+func retryDelay(resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err != nil {
+		return backoff(attempt), isTransientNetworkError(err)
+	}
+
+	switch {
+	case resp.StatusCode == 429:
+	case resp.StatusCode >= 500 && resp.StatusCode != 501:
+	default:
+		return 0, false
+	}
+
+	if wait, found := serverRequestedDelay(resp.Header); found {
+		return wait, wait <= RetryMaxDelay
+	}
+
+	return backoff(attempt), true
+}
+
+// exponential backoff with full jitter
+func backoff(attempt int) time.Duration {
+	maxDelay := RetryBaseDelay << attempt
+	if maxDelay > RetryMaxDelay || maxDelay <= 0 {
+		maxDelay = RetryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(maxDelay) + 1))
+}
+
+// Reads Retry-After and the RateLimit-* headers (as sent by docker hub)
+func serverRequestedDelay(header http.Header) (time.Duration, bool) {
+	if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if at, err := http.ParseTime(retryAfter); err == nil {
+			return time.Until(at), true
+		}
+	}
+
+	// e.g. RateLimit-Remaining: 0;w=21600
+	remaining, _, _ := strings.Cut(header.Get("RateLimit-Remaining"), ";")
+	if strings.TrimSpace(remaining) != "0" {
+		return 0, false
+	}
+	reset, _, _ := strings.Cut(header.Get("RateLimit-Reset"), ";")
+	if seconds, err := strconv.Atoi(strings.TrimSpace(reset)); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	return 0, false
+}
+
+func isTransientNetworkError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The number of parallel requests per host if not configured otherwise
+var DefaultConcurrency = 5
+
+// The number of requests per second per host if not configured
+// otherwise. 0 means unlimited.
+var DefaultRequestsPerSecond float64 = 0
+
+// a map that stores the scheduler to use per host so that
+// we don't create multiple registries and end up spamming
+// the host again.
+var schedulerByHost sync.Map
+
+// hostScheduler is the single place where requests to a host are
+// throttled. Every host has its own scheduler so a slow or limited
+// host never blocks the requests to other hosts.
+type hostScheduler struct {
+	// this cannel is used like n-locks. N is determined by
+	// the buffer size and allows max n goroutines to
+	// perform parallel requests. Others have to wait in
+	// the order they arrived...
+	slots chan struct{}
+
+	// 0 if the rate is not limited
+	interval time.Duration
+	mutex    sync.Mutex
+	next     time.Time
+}
+
+// Sets the number of parallel requests to the host
+func SetConcurrency(host string, n int) {
+	config := hostConfigs[host]
+	config.Concurrency = n
+	hostConfigs[host] = config
+}
+
+// Sets the number of requests per second to the host
+func SetRequestsPerSecond(host string, rps float64) {
+	config := hostConfigs[host]
+	config.RequestsPerSecond = rps
+	hostConfigs[host] = config
+}
+
+func schedulerFor(host string) *hostScheduler {
+	if scheduler, found := schedulerByHost.Load(host); found {
+		return scheduler.(*hostScheduler)
+	}
+
+	config := lookupHostConfig(host)
+
+	concurrency := DefaultConcurrency
+	if config.Concurrency > 0 {
+		concurrency = config.Concurrency
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	rps := DefaultRequestsPerSecond
+	if config.RequestsPerSecond > 0 {
+		rps = config.RequestsPerSecond
+	}
+
+	scheduler := &hostScheduler{
+		slots: make(chan struct{}, concurrency),
+	}
+	if rps > 0 {
+		scheduler.interval = time.Duration(float64(time.Second) / rps)
+	}
+
+	actual, _ := schedulerByHost.LoadOrStore(host, scheduler)
+	return actual.(*hostScheduler)
+}
+
+// Returns the number of requests that may run in parallel
+func (s *hostScheduler) concurrency() int {
+	return cap(s.slots)
+}
+
+// Blocks until the request may be made. Every successful
+// acquire must be followed by a release.
+func (s *hostScheduler) acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if err := s.wait(ctx); err != nil {
+		s.release()
+		return err
+	}
+	return nil
+}
+
+func (s *hostScheduler) release() {
+	<-s.slots
+}
+
+// waits for the next free slot of the rate limit
+func (s *hostScheduler) wait(ctx context.Context) error {
+	if s.interval == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	now := time.Now()
+	if s.next.Before(now) {
+		s.next = now
+	}
+	at := s.next
+	s.next = s.next.Add(s.interval)
+	s.mutex.Unlock()
+
+	return sleep(ctx, time.Until(at))
+}
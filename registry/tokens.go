@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tokenStore persists bearer tokens so that they are reused
+// across invocations until they expire.
+type tokenStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+type storedToken struct {
+	Token      string    `json:"token"`
+	Scope      string    `json:"scope"`
+	ValidUntil time.Time `json:"validUntil"`
+}
+
+// nil if tokens are not persisted. All methods can be called on nil.
+var tokens *tokenStore
+
+// Enables persisting bearer tokens in the file which
+// is created readable by the user only.
+func EnableTokenCache(path string) {
+	tokens = &tokenStore{path: expandUser(path)}
+}
+
+// Tokens are only valid for the credentials they were issued
+// to. The key is hashed so that the file does not contain secrets
+// besides the tokens themselves.
+func tokenKey(realm, service, scope string, creds *credentials) string {
+	parts := []string{realm, service, scope}
+	if creds != nil {
+		parts = append(parts, creds.username, creds.password, creds.identityToken)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *tokenStore) read() map[string]storedToken {
+	stored := make(map[string]storedToken)
+
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return stored
+	}
+	if err := json.Unmarshal(content, &stored); err != nil {
+		log.Debug().Str("path", s.path).Err(err).Msg("ignoring bad token cache")
+	}
+
+	return stored
+}
+
+// Returns the token if it is stored and still valid
+func (s *tokenStore) get(key string) (*token, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, found := s.read()[key]
+	if !found || !time.Now().Before(stored.ValidUntil) {
+		return nil, false
+	}
+
+	return &token{
+		token:      stored.Token,
+		scope:      stored.Scope,
+		validUntil: stored.ValidUntil,
+	}, true
+}
+
+// Stores the token and drops the expired ones. As with
+// the cache, failing to store a token is only logged.
+func (s *tokenStore) put(key string, t *token) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// re-read so that tokens of parallel runs are kept
+	stored := s.read()
+	for k, st := range stored {
+		if !time.Now().Before(st.ValidUntil) {
+			delete(stored, k)
+		}
+	}
+	stored[key] = storedToken{
+		Token:      t.token,
+		Scope:      t.scope,
+		ValidUntil: t.validUntil,
+	}
+
+	content, err := json.Marshal(stored)
+	if err != nil {
+		return
+	}
+	if err := writeFileAtomic(s.path, content); err != nil {
+		log.Debug().Str("path", s.path).Err(err).Msg("could not store token")
+	}
+}